@@ -38,6 +38,8 @@ func BenchmarkSet(b *testing.B) {
 	b.Run("setFind", runWith(benchmarkSetFind, 0))
 	b.Run("setFindM", runWith(benchmarkSetFindM, ns...))
 	b.Run("set", runWith(benchmarkSet, ns...))
+	b.Run("setFromSorted", runWith(benchmarkSetFromSorted, ns...))
+	b.Run("setMem", runWith(benchmarkSetMem, ns...))
 }
 
 func BenchmarkSet1E5(b *testing.B) {
@@ -181,6 +183,38 @@ func benchmarkSet(b *testing.B, m int) {
 	}
 }
 
+func benchmarkSetFromSorted(b *testing.B, m int) {
+	var keys = make([]int, m)
+	for i := 0; i < m; i++ {
+		keys[i] = i
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = rbtree.NewSetFromSorted(keys, rbtree.CompareInt, true)
+	}
+}
+
+// benchmarkSetMem report the bytes of spans per node,
+// and the bytes saved by storing color as a bit per node.
+func benchmarkSetMem(b *testing.B, m int) {
+	var keys = make([]int, m)
+	var rand = benchRand
+	for i := 0; i < m; i++ {
+		keys[i] = rand.Int()
+	}
+	b.ResetTimer()
+	var size, colorSaved int
+	for i := 0; i < b.N; i++ {
+		var set = rbtree.NewSet(int(0), rbtree.CompareInt)
+		for j := 0; j < m; j++ {
+			_, _ = set.Insert(keys[j])
+		}
+		size, colorSaved = rbtree.MemSize(set)
+	}
+	b.ReportMetric(float64(size)/float64(m), "span-B/node")
+	b.ReportMetric(float64(colorSaved)/float64(m), "color-saved-B/node")
+}
+
 func benchmarkSetFind(b *testing.B, n int) {
 	if n != 0 {
 		b.N = n
@@ -0,0 +1,156 @@
+//go:build gc && !rbtree_safe
+// +build gc,!rbtree_safe
+
+package comparator
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+type eface struct {
+	typ unsafe.Pointer
+	p   unsafe.Pointer
+}
+
+// dataOf return the pointer of data of x, x must not be direct interface
+func dataOf(x interface{}) unsafe.Pointer {
+	return (*eface)(unsafe.Pointer(&x)).p
+}
+
+// layoutOK report whether dataOf return the pointer of data, it's verified at init,
+// otherwise Builtin and ByField read the value by reflect.
+var layoutOK = checkLayout()
+
+// checkLayout read the samples by dataOf and compare them with reflect
+func checkLayout() (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	var samples = []interface{}{
+		int(-1), int8(-2), int16(-3), int32(-4), int64(-5),
+		uint(1), uint8(2), uint16(3), uint32(4), uint64(5), uintptr(6),
+		float32(1.5), float64(2.5), "string", []byte("bytes"), time.Unix(1, 2),
+		struct {
+			a int8
+			b string
+		}{1, "b"},
+	}
+	for _, x := range samples {
+		typ := reflect.TypeOf(x)
+		if isDirectIface(typ) || !reflect.DeepEqual(reflect.NewAt(typ, dataOf(x)).Elem().Interface(), x) {
+			return false
+		}
+	}
+	return true
+}
+
+// Builtin return a compare func of type typ.
+// it support all kinds of int, uint and float, string, []byte and time.Time,
+// including the named type of them. NaN is less than any other float and equal to NaN.
+// it return nil if typ is not supported.
+// the returned func doesn't alloc memory.
+func Builtin(typ reflect.Type) func(a, b interface{}) int {
+	if !layoutOK {
+		return reflectBuiltin(typ)
+	}
+	compare := pointerCompare(typ)
+	if compare == nil {
+		return nil
+	}
+	return func(a, b interface{}) int {
+		return compare(dataOf(a), dataOf(b))
+	}
+}
+
+// pointerCompare return a func to compare the value pointed by a and b of type typ
+func pointerCompare(typ reflect.Type) func(a, b unsafe.Pointer) int {
+	if typ == timeType {
+		return func(a, b unsafe.Pointer) int { return compareTime(*(*time.Time)(a), *(*time.Time)(b)) }
+	}
+	switch typ.Kind() {
+	case reflect.Int:
+		return func(a, b unsafe.Pointer) int { return compareInt64(int64(*(*int)(a)), int64(*(*int)(b))) }
+	case reflect.Int8:
+		return func(a, b unsafe.Pointer) int { return compareInt64(int64(*(*int8)(a)), int64(*(*int8)(b))) }
+	case reflect.Int16:
+		return func(a, b unsafe.Pointer) int { return compareInt64(int64(*(*int16)(a)), int64(*(*int16)(b))) }
+	case reflect.Int32:
+		return func(a, b unsafe.Pointer) int { return compareInt64(int64(*(*int32)(a)), int64(*(*int32)(b))) }
+	case reflect.Int64:
+		return func(a, b unsafe.Pointer) int { return compareInt64(*(*int64)(a), *(*int64)(b)) }
+	case reflect.Uint:
+		return func(a, b unsafe.Pointer) int { return compareUint64(uint64(*(*uint)(a)), uint64(*(*uint)(b))) }
+	case reflect.Uint8:
+		return func(a, b unsafe.Pointer) int { return compareUint64(uint64(*(*uint8)(a)), uint64(*(*uint8)(b))) }
+	case reflect.Uint16:
+		return func(a, b unsafe.Pointer) int { return compareUint64(uint64(*(*uint16)(a)), uint64(*(*uint16)(b))) }
+	case reflect.Uint32:
+		return func(a, b unsafe.Pointer) int { return compareUint64(uint64(*(*uint32)(a)), uint64(*(*uint32)(b))) }
+	case reflect.Uint64:
+		return func(a, b unsafe.Pointer) int { return compareUint64(*(*uint64)(a), *(*uint64)(b)) }
+	case reflect.Uintptr:
+		return func(a, b unsafe.Pointer) int { return compareUint64(uint64(*(*uintptr)(a)), uint64(*(*uintptr)(b))) }
+	case reflect.Float32:
+		return func(a, b unsafe.Pointer) int { return compareFloat64(float64(*(*float32)(a)), float64(*(*float32)(b))) }
+	case reflect.Float64:
+		return func(a, b unsafe.Pointer) int { return compareFloat64(*(*float64)(a), *(*float64)(b)) }
+	case reflect.String:
+		return func(a, b unsafe.Pointer) int { return compareString(*(*string)(a), *(*string)(b)) }
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return func(a, b unsafe.Pointer) int { return bytes.Compare(*(*[]byte)(a), *(*[]byte)(b)) }
+		}
+	}
+	return nil
+}
+
+// ByField return a compare func which compare the field named name of struct,
+// sample is a value of the struct type, and the key compared must be this type.
+// if compare is nil, it use the Builtin compare func of field type and doesn't alloc memory,
+// otherwise compare is called with the field value.
+// it panic with ErrNotStruct, ErrNoField, ErrPointerField, ErrDirectIface or ErrNoBuiltin.
+func ByField(sample interface{}, name string, compare func(a, b interface{}) int) func(a, b interface{}) int {
+	if !layoutOK {
+		return reflectByField(sample, name, compare)
+	}
+	typ := reflect.TypeOf(sample)
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Errorf("%w: %s", ErrNotStruct, typ))
+	}
+	field, ok := typ.FieldByName(name)
+	if !ok {
+		panic(fmt.Errorf("%w: %s.%s", ErrNoField, typ, name))
+	}
+	var offset uintptr
+	for i, t := 0, typ; i < len(field.Index); i++ {
+		if t.Kind() != reflect.Struct {
+			panic(fmt.Errorf("%w: %s.%s", ErrPointerField, typ, name))
+		}
+		f := t.Field(field.Index[i])
+		offset += f.Offset
+		t = f.Type
+	}
+	if isDirectIface(typ) {
+		panic(fmt.Errorf("%w: %s", ErrDirectIface, typ))
+	}
+	if compare == nil {
+		pcompare := pointerCompare(field.Type)
+		if pcompare == nil {
+			panic(fmt.Errorf("%w: %s.%s", ErrNoBuiltin, typ, name))
+		}
+		return func(a, b interface{}) int {
+			return pcompare(unsafe.Pointer(uintptr(dataOf(a))+offset), unsafe.Pointer(uintptr(dataOf(b))+offset))
+		}
+	}
+	return func(a, b interface{}) int {
+		x := reflect.NewAt(field.Type, unsafe.Pointer(uintptr(dataOf(a))+offset)).Elem().Interface()
+		y := reflect.NewAt(field.Type, unsafe.Pointer(uintptr(dataOf(b))+offset)).Elem().Interface()
+		return compare(x, y)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !gc || rbtree_safe
+// +build !gc rbtree_safe
+
+package comparator
+
+import (
+	"reflect"
+)
+
+// Builtin return a compare func of type typ.
+// it support all kinds of int, uint and float, string, []byte and time.Time,
+// including the named type of them. NaN is less than any other float and equal to NaN.
+// it return nil if typ is not supported.
+// the returned func read the value by reflect in the rbtree_safe build.
+func Builtin(typ reflect.Type) func(a, b interface{}) int {
+	return reflectBuiltin(typ)
+}
+
+// ByField return a compare func which compare the field named name of struct,
+// sample is a value of the struct type, and the key compared must be this type.
+// if compare is nil, it use the Builtin compare func of field type,
+// otherwise compare is called with the field value.
+// it panic with ErrNotStruct, ErrNoField, ErrPointerField, ErrDirectIface or ErrNoBuiltin.
+func ByField(sample interface{}, name string, compare func(a, b interface{}) int) func(a, b interface{}) int {
+	return reflectByField(sample, name, compare)
+}
@@ -0,0 +1,27 @@
+//go:build gc && !rbtree_safe
+// +build gc,!rbtree_safe
+
+package comparator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLayoutFallback(t *testing.T) {
+	if !layoutOK {
+		t.Log("the layout check fails, Builtin and ByField use reflect")
+	}
+	defer func(ok bool) {
+		layoutOK = ok
+	}(layoutOK)
+	layoutOK = false
+	var compare = Builtin(reflect.TypeOf(0))
+	var byName = ByField(point{}, "Name", nil)
+	if compare(1, 2) >= 0 || compare(2, 1) <= 0 || compare(1, 1) != 0 {
+		t.Fatal("reflect Builtin error")
+	}
+	if byName(point{Name: "a"}, point{Name: "b"}) >= 0 {
+		t.Fatal("reflect ByField error")
+	}
+}
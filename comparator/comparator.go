@@ -0,0 +1,103 @@
+// Package comparator provides compare funcs for rbtree.
+// A compare func return a negative number if a < b, 0 if a == b,
+// and a positive number if a > b.
+package comparator
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"time"
+)
+
+// the errors ByField panic with, they are wrapped with the type or field name,
+// so use errors.Is to match them.
+var (
+	ErrNotStruct    = errors.New("comparator: not struct")
+	ErrNoField      = errors.New("comparator: struct has no such field")
+	ErrPointerField = errors.New("comparator: field is promoted through a pointer")
+	ErrDirectIface  = errors.New("comparator: struct is stored directly in interface")
+	ErrNoBuiltin    = errors.New("comparator: no builtin compare func for field")
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func compareInt64(x, y int64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(x, y uint64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+// compareFloat64 take NaN as the smallest float
+func compareFloat64(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	case x == y:
+		return 0
+	}
+	// at least one of x and y is NaN
+	if math.IsNaN(x) {
+		if math.IsNaN(y) {
+			return 0
+		}
+		return -1
+	}
+	return 1
+}
+
+func compareString(x, y string) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+// Reverse return a compare func whose order is reverse of compare.
+func Reverse(compare func(a, b interface{}) int) func(a, b interface{}) int {
+	return func(a, b interface{}) int {
+		return compare(b, a)
+	}
+}
+
+// Lexicographic return a compare func for composite key,
+// it compare a and b by compares one by one until the result is not 0.
+func Lexicographic(compares ...func(a, b interface{}) int) func(a, b interface{}) int {
+	return func(a, b interface{}) int {
+		for _, compare := range compares {
+			if cmp := compare(a, b); cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	}
+}
+
+// isDirectIface report whether the value of typ is stored directly in interface
+func isDirectIface(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Chan, reflect.Map, reflect.Func, reflect.UnsafePointer:
+		return true
+	case reflect.Array:
+		return typ.Len() == 1 && isDirectIface(typ.Elem())
+	case reflect.Struct:
+		return typ.NumField() == 1 && isDirectIface(typ.Field(0).Type)
+	}
+	return false
+}
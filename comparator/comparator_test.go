@@ -0,0 +1,125 @@
+package comparator
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type myInt int8
+
+type point struct {
+	X, Y int
+	Name string
+}
+
+type named struct {
+	point
+	Score float32
+}
+
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	} else if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+func TestBuiltin(t *testing.T) {
+	var now = time.Now()
+	var cases = []struct {
+		a, b interface{}
+		cmp  int
+	}{
+		{math.MinInt64, math.MaxInt64, -1}, // a - b overflow
+		{int(3), int(3), 0},
+		{myInt(-1), myInt(1), -1},
+		{int16(5), int16(-5), 1},
+		{int32(math.MinInt32), int32(1), -1},
+		{uint(1), uint(0), 1},
+		{uint8(255), uint8(0), 1},
+		{uint16(1), uint16(2), -1},
+		{uint32(7), uint32(7), 0},
+		{uint64(math.MaxUint64), uint64(0), 1},
+		{uintptr(1), uintptr(2), -1},
+		{float32(1.5), float32(-1.5), 1},
+		{math.NaN(), math.Inf(-1), -1},
+		{math.NaN(), math.NaN(), 0},
+		{0.0, math.Copysign(0, -1), 0},
+		{1.0, math.NaN(), 1},
+		{"abc", "abd", -1},
+		{"", "", 0},
+		{[]byte("b"), []byte("a"), 1},
+		{now, now.Add(time.Second), -1},
+		{now, now, 0},
+	}
+	for _, c := range cases {
+		compare := Builtin(reflect.TypeOf(c.a))
+		if compare == nil {
+			t.Fatalf("no builtin compare func of %T", c.a)
+		}
+		if cmp := compare(c.a, c.b); sign(cmp) != c.cmp {
+			t.Fatalf("compare %T %v %v = %d, want %d", c.a, c.a, c.b, cmp, c.cmp)
+		}
+		if cmp := compare(c.b, c.a); sign(cmp) != -c.cmp {
+			t.Fatalf("compare %T %v %v = %d, want %d", c.a, c.b, c.a, cmp, -c.cmp)
+		}
+	}
+	for _, x := range []interface{}{true, struct{}{}, new(int), []int{}, complex(1, 1)} {
+		if Builtin(reflect.TypeOf(x)) != nil {
+			t.Fatalf("%T should not have builtin compare func", x)
+		}
+	}
+}
+
+func TestBuiltinNoAlloc(t *testing.T) {
+	var compare = Builtin(reflect.TypeOf(int64(0)))
+	var a, b interface{} = int64(1000), int64(2000)
+	n := testing.AllocsPerRun(1000, func() {
+		compare(a, b)
+	})
+	if n > 0 {
+		t.Fatal("compare alloc", n)
+	}
+}
+
+func TestHelper(t *testing.T) {
+	var byName = ByField(named{}, "Name", nil)
+	var byXY = Lexicographic(ByField(named{}, "X", nil), ByField(named{}, "Y", Reverse(Builtin(reflect.TypeOf(0)))))
+	var byScore = Reverse(ByField(named{}, "Score", func(a, b interface{}) int {
+		return compareFloat64(float64(a.(float32)), float64(b.(float32)))
+	}))
+	var a = named{point{1, 2, "a"}, 1}
+	var b = named{point{1, 3, "b"}, 2}
+	if byName(a, b) >= 0 || byName(b, a) <= 0 || byName(a, a) != 0 {
+		t.Fatal("ByField error")
+	}
+	if byXY(a, b) <= 0 || byXY(b, a) >= 0 || byXY(a, a) != 0 {
+		t.Fatal("Lexicographic or Reverse error")
+	}
+	if byScore(a, b) <= 0 {
+		t.Fatal("ByField with compare error")
+	}
+	var mustPanic = func(err error, f func()) {
+		defer func() {
+			if e, _ := recover().(error); !errors.Is(e, err) {
+				t.Fatal("should panic with", err, e)
+			}
+		}()
+		f()
+	}
+	type embed struct {
+		*point
+		P   *int
+		Any interface{}
+	}
+	mustPanic(ErrNoField, func() { ByField(a, "Z", nil) })
+	mustPanic(ErrNotStruct, func() { ByField(1, "X", nil) })
+	mustPanic(ErrDirectIface, func() { ByField(struct{ P *int }{}, "P", Builtin(reflect.TypeOf(0))) })
+	mustPanic(ErrPointerField, func() { ByField(embed{}, "X", nil) })
+	mustPanic(ErrNoBuiltin, func() { ByField(embed{}, "Any", nil) })
+}
@@ -0,0 +1,96 @@
+package comparator
+
+// this file is the reflect implementation of Builtin and ByField,
+// it's used by the rbtree_safe build, or by the gc build if the layout check fails.
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// reflectBuiltin is the Builtin which read the value by reflect
+func reflectBuiltin(typ reflect.Type) func(a, b interface{}) int {
+	if typ == timeType {
+		return func(a, b interface{}) int {
+			return compareTime(a.(time.Time), b.(time.Time))
+		}
+	}
+	compare := valueCompare(typ)
+	if compare == nil {
+		return nil
+	}
+	return func(a, b interface{}) int {
+		return compare(reflect.ValueOf(a), reflect.ValueOf(b))
+	}
+}
+
+// valueCompare return a func to compare the value a and b of type typ
+func valueCompare(typ reflect.Type) func(a, b reflect.Value) int {
+	if typ == timeType {
+		return func(a, b reflect.Value) int {
+			return compareTime(a.Interface().(time.Time), b.Interface().(time.Time))
+		}
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b reflect.Value) int { return compareInt64(a.Int(), b.Int()) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(a, b reflect.Value) int { return compareUint64(a.Uint(), b.Uint()) }
+	case reflect.Float32, reflect.Float64:
+		return func(a, b reflect.Value) int { return compareFloat64(a.Float(), b.Float()) }
+	case reflect.String:
+		return func(a, b reflect.Value) int { return compareString(a.String(), b.String()) }
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return func(a, b reflect.Value) int { return bytes.Compare(a.Bytes(), b.Bytes()) }
+		}
+	}
+	return nil
+}
+
+func compareTime(x, y time.Time) int {
+	if x.Before(y) {
+		return -1
+	} else if x.After(y) {
+		return 1
+	}
+	return 0
+}
+
+// reflectByField is the ByField which read the field by reflect
+func reflectByField(sample interface{}, name string, compare func(a, b interface{}) int) func(a, b interface{}) int {
+	typ := reflect.TypeOf(sample)
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Errorf("%w: %s", ErrNotStruct, typ))
+	}
+	field, ok := typ.FieldByName(name)
+	if !ok {
+		panic(fmt.Errorf("%w: %s.%s", ErrNoField, typ, name))
+	}
+	for i, t := 0, typ; i < len(field.Index); i++ {
+		if t.Kind() != reflect.Struct {
+			panic(fmt.Errorf("%w: %s.%s", ErrPointerField, typ, name))
+		}
+		t = t.Field(field.Index[i]).Type
+	}
+	// same as the unsafe ByField, which read the field by the pointer of data in interface
+	if isDirectIface(typ) {
+		panic(fmt.Errorf("%w: %s", ErrDirectIface, typ))
+	}
+	if compare == nil {
+		vcompare := valueCompare(field.Type)
+		if vcompare == nil {
+			panic(fmt.Errorf("%w: %s.%s", ErrNoBuiltin, typ, name))
+		}
+		return func(a, b interface{}) int {
+			return vcompare(reflect.ValueOf(a).FieldByIndex(field.Index), reflect.ValueOf(b).FieldByIndex(field.Index))
+		}
+	}
+	return func(a, b interface{}) int {
+		x := reflect.ValueOf(a).FieldByIndex(field.Index).Interface()
+		y := reflect.ValueOf(b).FieldByIndex(field.Index).Interface()
+		return compare(x, y)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package rbtree_test
+
+import (
+	"fmt"
+
+	"github.com/cdongyang/rbtree"
+)
+
+func ExampleMapOf() {
+	var slice = []int{1, 4, 6, 5, 3, 7, 2, 9}
+	// key type: int, value type: *int, no type assert is needed
+	mp := rbtree.NewMapOf[int, *int](func(a, b int) int {
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	})
+	for i := range slice {
+		mp.Insert(slice[i], &slice[i])
+	}
+	for it := mp.Begin(); it != mp.End(); it = it.Next() {
+		fmt.Println(it.GetKey(), *it.GetVal())
+	}
+	//Output:
+	//1 1
+	//2 2
+	//3 3
+	//4 4
+	//5 5
+	//6 6
+	//7 7
+	//9 9
+}
@@ -4,13 +4,13 @@ import (
 	"fmt"
 	"sort"
 	"testing"
-	"unsafe"
 
 	"github.com/cdongyang/rbtree"
 )
 
 type IntSetNode struct {
 	n rbtree.SetNode
+	s *IntSet
 }
 
 func (n IntSetNode) GetData() int {
@@ -18,15 +18,15 @@ func (n IntSetNode) GetData() int {
 }
 
 func (n IntSetNode) Next() IntSetNode {
-	return IntSetNode{n: n.n.Next()}
+	return n.s.pack(n.n.Next())
 }
 
 func (n IntSetNode) Last() IntSetNode {
-	return IntSetNode{n: n.n.Next()}
+	return n.s.pack(n.n.Last())
 }
 
 func (n IntSetNode) GetSet() *IntSet {
-	return (*IntSet)(unsafe.Pointer(n.GetSet()))
+	return n.s
 }
 
 type IntSet struct {
@@ -46,7 +46,7 @@ func NewMultiIntSet(compare func(a, b int) int) *IntSet {
 }
 
 func (s *IntSet) pack(n rbtree.SetNode) IntSetNode {
-	return IntSetNode{n: n}
+	return IntSetNode{n: n, s: s}
 }
 
 func (s *IntSet) Init(unique bool, compare func(a, b int) int) {
@@ -136,6 +136,9 @@ func TestIntSet(t *testing.T) {
 		}
 	})
 	t.Run("escape", func(t *testing.T) {
+		if rbtree.ReflectOnly() {
+			t.Skip("arguments escape to heap when key and value are read by reflect")
+		}
 		var x = 1
 		s := NewIntSet(func(a, b int) int { return a - b })
 		n := testing.AllocsPerRun(1000, func() {
@@ -191,8 +194,11 @@ func (s *intSet) Erase(data int) (ok bool) {
 }
 
 func (s *intSet) Range(f func(data int) bool) {
-	for it := s.set.Begin(); it != s.set.End(); it = it.Next() {
-		ok := f(it.GetData().(int))
+	// move to next node before calling f, because f may erase current node
+	for it := s.set.Begin(); it != s.set.End(); {
+		data := it.GetData().(int)
+		it = it.Next()
+		ok := f(data)
 		if !ok {
 			return
 		}
@@ -236,6 +242,9 @@ func TestIntSetNoIterator(t *testing.T) {
 		}
 	})
 	t.Run("escape", func(t *testing.T) {
+		if rbtree.ReflectOnly() {
+			t.Skip("arguments escape to heap when key and value are read by reflect")
+		}
 		var x = 1
 		s := NewintSet(func(a, b int) int { return a - b })
 		n := testing.AllocsPerRun(1000, func() {
@@ -295,8 +304,11 @@ func (m *intMap) Delete(key int) (ok bool) {
 }
 
 func (m *intMap) Range(f func(key int, val *int) bool) {
-	for it := m.mp.Begin(); it != m.mp.End(); it = it.Next() {
-		ok := f(it.GetKey().(int), it.GetVal().(*int))
+	// move to next node before calling f, because f may erase current node
+	for it := m.mp.Begin(); it != m.mp.End(); {
+		key, val := it.GetKey().(int), it.GetVal().(*int)
+		it = it.Next()
+		ok := f(key, val)
 		if !ok {
 			return
 		}
@@ -339,6 +351,9 @@ func TestIntMapNoIterator(t *testing.T) {
 		}
 	})
 	t.Run("escape", func(t *testing.T) {
+		if rbtree.ReflectOnly() {
+			t.Skip("arguments escape to heap when key and value are read by reflect")
+		}
 		var x = 1
 		s := NewIntMap(func(a, b int) int { return a - b })
 		n := testing.AllocsPerRun(1000, func() {
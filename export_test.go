@@ -0,0 +1,18 @@
+package rbtree
+
+import "reflect"
+
+// ReflectOnly is exported for the tests of package rbtree_test,
+// key and value are read and written only by reflect if it return true.
+func ReflectOnly() bool {
+	return reflectOnly
+}
+
+// MemSize return the bytes of spans of s, and the bytes saved
+// by storing color as a bit instead of a colorType.
+func MemSize(s *Set) (size, colorSaved int) {
+	for i := range s.spans {
+		colorSaved += int(s.spans[i].size*reflect.TypeOf(colorType(false)).Size() - colorMemSize(s.spans[i].size))
+	}
+	return s.memSize(), colorSaved
+}
@@ -0,0 +1,522 @@
+//go:build go1.18
+// +build go1.18
+
+package rbtree
+
+import (
+	"reflect"
+)
+
+// treeOf is the tree with a typed compare func,
+// key is read from span directly, so it never box to interface{}
+type treeOf[K any] struct {
+	tree
+	compare func(a, b K) int
+}
+
+// init panic with ErrNoCompare if compare is nil,
+// there is no builtin compare func for the generic trees.
+func (t *treeOf[K]) init(unique bool, valType reflect.Type, compare func(a, b K) int) {
+	if compare == nil {
+		panic(ErrNoCompare)
+	}
+	t.compare = compare
+	t.tree.initType(unique, reflect.TypeOf((*K)(nil)).Elem(), valType, nil)
+}
+
+func (t *treeOf[K]) key(n node) K {
+	return *keyOf[K](&t.tree, n)
+}
+
+func (t *treeOf[K]) find(key K) node {
+	var root = t.root()
+	for {
+		if sameNode(root, t.end()) {
+			return root
+		}
+		switch cmp := t.compare(key, t.key(root)); {
+		case cmp == 0:
+			return root
+		case cmp < 0:
+			root = t.getChild(root, 0)
+		case cmp > 0:
+			root = t.getChild(root, 1)
+		}
+	}
+}
+
+// insert link a new node of key to tree and return it,
+// the value of new node is zero value.
+func (t *treeOf[K]) insert(key K) (node, bool) {
+	var root = t.root()
+	var parent = t.end()
+	var ch uintptr
+	for !sameNode(root, t.end()) {
+		parent = root
+		switch cmp := t.compare(key, t.key(root)); {
+		case cmp == 0:
+			if t.unique {
+				return root, false
+			}
+			fallthrough
+		case cmp < 0:
+			ch = 0
+		case cmp > 0:
+			ch = 1
+		}
+		root = t.getChild(root, ch)
+	}
+	var n = t.allocNode()
+	*keyOf[K](&t.tree, n) = key
+	t.link(parent, ch, n)
+	return n, true
+}
+
+func (t *treeOf[K]) lowerBound(key K) node {
+	var root = t.root()
+	var result = t.end()
+	for !sameNode(root, t.end()) {
+		if t.compare(key, t.key(root)) > 0 {
+			root = t.getChild(root, 1)
+		} else {
+			result = root
+			root = t.getChild(root, 0)
+		}
+	}
+	return result
+}
+
+func (t *treeOf[K]) upperBound(key K) node {
+	var root = t.root()
+	var result = t.end()
+	for !sameNode(root, t.end()) {
+		if t.compare(key, t.key(root)) >= 0 {
+			root = t.getChild(root, 1)
+		} else {
+			result = root
+			root = t.getChild(root, 0)
+		}
+	}
+	return result
+}
+
+func (t *treeOf[K]) count(key K) (count int) {
+	if t.unique {
+		if sameNode(t.find(key), t.end()) {
+			return 0
+		}
+		return 1
+	}
+	return t.indexOf(t.upperBound(key)) - t.indexOf(t.lowerBound(key))
+}
+
+func (t *treeOf[K]) erase(key K) (count int) {
+	if t.unique {
+		var iter = t.find(key)
+		if sameNode(iter, t.end()) {
+			return 0
+		}
+		t.eraseNode(iter)
+		return 1
+	}
+	var beg = t.lowerBound(key)
+	for !sameNode(beg, t.end()) && t.compare(key, t.key(beg)) == 0 {
+		var tmp = t.next(beg)
+		t.eraseNode(beg)
+		beg = tmp
+		count++
+	}
+	return count
+}
+
+func (t *treeOf[K]) cloneTo(c *treeOf[K]) {
+	c.compare = t.compare
+	t.tree.cloneTo(&c.tree)
+}
+
+// MapOfNode is the typed iterator of MapOf, but it's not thread safe,
+// if the node was erase from map, calling it's method will panic with ErrStaleNode
+type MapOfNode[K, V any] struct {
+	n _node
+}
+
+// GetKey get the key of MapOfNode.
+func (n MapOfNode[K, V]) GetKey() K {
+	n.n.tree.checkNode(n.n)
+	return *keyOf[K](n.n.tree, n.n.node)
+}
+
+// GetVal get the value of MapOfNode.
+func (n MapOfNode[K, V]) GetVal() V {
+	n.n.tree.checkNode(n.n)
+	return *valOf[V](n.n.tree, n.n.node)
+}
+
+func (n MapOfNode[K, V]) GetData() (key K, val V) {
+	return n.GetKey(), n.GetVal()
+}
+
+func (n MapOfNode[K, V]) SetVal(val V) {
+	n.n.tree.checkNode(n.n)
+	*valOf[V](n.n.tree, n.n.node) = val
+}
+
+func (n MapOfNode[K, V]) Next() MapOfNode[K, V] {
+	return MapOfNode[K, V]{n.n.Next()}
+}
+
+func (n MapOfNode[K, V]) Last() MapOfNode[K, V] {
+	return MapOfNode[K, V]{n.n.Last()}
+}
+
+// GetMap return the MapOf that current node belong to, or nil if n is the zero value.
+func (n MapOfNode[K, V]) GetMap() *MapOf[K, V] {
+	if n.n.tree == nil {
+		return nil
+	}
+	m, _ := n.n.tree.owner.(*MapOf[K, V])
+	return m
+}
+
+// MapOf is the generic version of Map, key and value are stored in span
+// with it's own type, so they never box to interface{}.
+type MapOf[K, V any] struct {
+	t treeOf[K]
+}
+
+// newMapOf return a MapOf which is not inited, it's the owner of it's tree
+func newMapOf[K, V any]() *MapOf[K, V] {
+	var m = &MapOf[K, V]{}
+	m.t.owner = m
+	return m
+}
+
+// NewMapOf return a unique map with compare func,
+// it panic with ErrNoCompare if compare is nil.
+func NewMapOf[K, V any](compare func(a, b K) int) *MapOf[K, V] {
+	var m = newMapOf[K, V]()
+	m.Init(true, compare)
+	return m
+}
+
+func (m *MapOf[K, V]) pack(n node) MapOfNode[K, V] {
+	return MapOfNode[K, V]{n: m.t.pack(n)}
+}
+
+// Init init the map, function NewMapOf will calle it,
+// only the first call of this function will have an affect on map,
+// it panic with ErrNoCompare if compare is nil.
+func (m *MapOf[K, V]) Init(unique bool, compare func(a, b K) int) {
+	m.t.owner = m
+	m.t.onceInit.Do(func() {
+		m.t.init(unique, reflect.TypeOf((*V)(nil)).Elem(), compare)
+	})
+}
+
+func (m *MapOf[K, V]) Begin() MapOfNode[K, V] {
+	return m.pack(m.t.begin())
+}
+
+func (m *MapOf[K, V]) End() MapOfNode[K, V] {
+	return m.pack(m.t.end())
+}
+
+func (m *MapOf[K, V]) EqualRange(key K) (beg, end MapOfNode[K, V]) {
+	return m.pack(m.t.lowerBound(key)), m.pack(m.t.upperBound(key))
+}
+
+func (m *MapOf[K, V]) EraseNode(n MapOfNode[K, V]) {
+	m.t.EraseNode(n.n)
+}
+
+func (m *MapOf[K, V]) EraseNodeRange(beg, end MapOfNode[K, V]) (count int) {
+	return m.t.EraseNodeRange(beg.n, end.n)
+}
+
+func (m *MapOf[K, V]) Find(key K) MapOfNode[K, V] {
+	return m.pack(m.t.find(key))
+}
+
+func (m *MapOf[K, V]) Insert(key K, val V) (MapOfNode[K, V], bool) {
+	n, ok := m.t.insert(key)
+	if ok {
+		*valOf[V](&m.t.tree, n) = val
+	}
+	return m.pack(n), ok
+}
+
+func (m *MapOf[K, V]) LowerBound(key K) MapOfNode[K, V] {
+	return m.pack(m.t.lowerBound(key))
+}
+
+func (m *MapOf[K, V]) UpperBound(key K) MapOfNode[K, V] {
+	return m.pack(m.t.upperBound(key))
+}
+
+func (m *MapOf[K, V]) Count(key K) (count int) {
+	return m.t.count(key)
+}
+
+func (m *MapOf[K, V]) Erase(key K) (count int) {
+	return m.t.erase(key)
+}
+
+// Clone return a new map which has the same compare func, unique flag,
+// max span and data with m, but it doesn't share memory with m.
+// O(n)
+func (m *MapOf[K, V]) Clone() *MapOf[K, V] {
+	var c = newMapOf[K, V]()
+	m.t.cloneTo(&c.t)
+	return c
+}
+
+// CloneWith is like Clone, but it replace every value of the new map with copyVal(value).
+// O(n)
+func (m *MapOf[K, V]) CloneWith(copyVal func(val V) V) *MapOf[K, V] {
+	var c = m.Clone()
+	for n := c.t.begin(); !sameNode(n, c.t.end()); n = c.t.next(n) {
+		p := valOf[V](&c.t.tree, n)
+		*p = copyVal(*p)
+	}
+	return c
+}
+
+func (m *MapOf[K, V]) Size() int {
+	return m.t.Size()
+}
+
+func (m *MapOf[K, V]) Empty() bool {
+	return m.t.Empty()
+}
+
+func (m *MapOf[K, V]) Unique() bool {
+	return m.t.Unique()
+}
+
+func (m *MapOf[K, V]) SetMaxSpan(maxSpan uint32) {
+	m.t.SetMaxSpan(maxSpan)
+}
+
+func (m *MapOf[K, V]) GetMaxSpan() uint32 {
+	return m.t.GetMaxSpan()
+}
+
+// SetOfNode is the typed iterator of SetOf, but it's not thread safe,
+// if the node was erase from set, calling it's method will panic with ErrStaleNode
+type SetOfNode[T any] struct {
+	n _node
+}
+
+// GetData get the data of SetOfNode.
+func (n SetOfNode[T]) GetData() T {
+	n.n.tree.checkNode(n.n)
+	return *keyOf[T](n.n.tree, n.n.node)
+}
+
+// Next return the next node of current node.
+// it will panic if current node equal to set.End().
+func (n SetOfNode[T]) Next() SetOfNode[T] {
+	return SetOfNode[T]{n.n.Next()}
+}
+
+// Last return the last node of current node.
+// it will panic if current node equal to set.Begin().
+func (n SetOfNode[T]) Last() SetOfNode[T] {
+	return SetOfNode[T]{n.n.Last()}
+}
+
+// GetSet return the SetOf that current node belong to, or nil if n is the zero value.
+func (n SetOfNode[T]) GetSet() *SetOf[T] {
+	if n.n.tree == nil {
+		return nil
+	}
+	s, _ := n.n.tree.owner.(*SetOf[T])
+	return s
+}
+
+// SetOf is the generic version of Set, data is stored in span
+// with it's own type, so it never box to interface{}.
+type SetOf[T any] struct {
+	t treeOf[T]
+}
+
+// newSetOf return a SetOf which is not inited, it's the owner of it's tree
+func newSetOf[T any]() *SetOf[T] {
+	var s = &SetOf[T]{}
+	s.t.owner = s
+	return s
+}
+
+// NewSetOf return a unique set with compare func,
+// it panic with ErrNoCompare if compare is nil.
+func NewSetOf[T any](compare func(a, b T) int) *SetOf[T] {
+	var s = newSetOf[T]()
+	s.Init(true, compare)
+	return s
+}
+
+func (s *SetOf[T]) pack(n node) SetOfNode[T] {
+	return SetOfNode[T]{n: s.t.pack(n)}
+}
+
+// Init init the set, function NewSetOf will calle it,
+// only the first call of this function will have an affect on set,
+// it panic with ErrNoCompare if compare is nil.
+func (s *SetOf[T]) Init(unique bool, compare func(a, b T) int) {
+	s.t.owner = s
+	s.t.onceInit.Do(func() {
+		s.t.init(unique, nil, compare)
+	})
+}
+
+// Begin return the first SetOfNode of set,
+// if set is empty, it return set.End()
+func (s *SetOf[T]) Begin() SetOfNode[T] {
+	return s.pack(s.t.begin())
+}
+
+// End represent the end of set,but it isn't a real node
+func (s *SetOf[T]) End() SetOfNode[T] {
+	return s.pack(s.t.end())
+}
+
+func (s *SetOf[T]) EqualRange(data T) (beg, end SetOfNode[T]) {
+	return s.pack(s.t.lowerBound(data)), s.pack(s.t.upperBound(data))
+}
+
+// EraseNode erase a SetOfNode from tree,
+// if SetOfNode has been erased, calling will panic
+func (s *SetOf[T]) EraseNode(n SetOfNode[T]) {
+	s.t.EraseNode(n.n)
+}
+
+func (s *SetOf[T]) EraseNodeRange(beg, end SetOfNode[T]) (count int) {
+	return s.t.EraseNodeRange(beg.n, end.n)
+}
+
+func (s *SetOf[T]) Find(data T) SetOfNode[T] {
+	return s.pack(s.t.find(data))
+}
+
+func (s *SetOf[T]) Insert(data T) (SetOfNode[T], bool) {
+	n, ok := s.t.insert(data)
+	return s.pack(n), ok
+}
+
+func (s *SetOf[T]) LowerBound(data T) SetOfNode[T] {
+	return s.pack(s.t.lowerBound(data))
+}
+
+func (s *SetOf[T]) UpperBound(data T) SetOfNode[T] {
+	return s.pack(s.t.upperBound(data))
+}
+
+func (s *SetOf[T]) Count(data T) (count int) {
+	return s.t.count(data)
+}
+
+func (s *SetOf[T]) Erase(data T) (count int) {
+	return s.t.erase(data)
+}
+
+// Clone return a new set which has the same compare func, unique flag,
+// max span and data with s, but it doesn't share memory with s.
+// O(n)
+func (s *SetOf[T]) Clone() *SetOf[T] {
+	var c = newSetOf[T]()
+	s.t.cloneTo(&c.t)
+	return c
+}
+
+func (s *SetOf[T]) Size() int {
+	return s.t.Size()
+}
+
+func (s *SetOf[T]) Empty() bool {
+	return s.t.Empty()
+}
+
+func (s *SetOf[T]) Unique() bool {
+	return s.t.Unique()
+}
+
+func (s *SetOf[T]) SetMaxSpan(maxSpan uint32) {
+	s.t.SetMaxSpan(maxSpan)
+}
+
+func (s *SetOf[T]) GetMaxSpan() uint32 {
+	return s.t.GetMaxSpan()
+}
+
+// MultiMapOf is the generic version of the not unique Map, it has all the methods of MapOf,
+// and the nodes of it belong to the embedded MapOf.
+type MultiMapOf[K, V any] struct {
+	MapOf[K, V]
+}
+
+// NewMultiMapOf return a not unique map with compare func,
+// it panic with ErrNoCompare if compare is nil.
+func NewMultiMapOf[K, V any](compare func(a, b K) int) *MultiMapOf[K, V] {
+	var m = &MultiMapOf[K, V]{}
+	m.Init(compare)
+	return m
+}
+
+// Init init the map, function NewMultiMapOf will calle it,
+// only the first call of this function will have an affect on map,
+// it panic with ErrNoCompare if compare is nil.
+func (m *MultiMapOf[K, V]) Init(compare func(a, b K) int) {
+	m.MapOf.Init(false, compare)
+}
+
+// Clone return a new map which has the same compare func,
+// max span and data with m, but it doesn't share memory with m.
+// O(n)
+func (m *MultiMapOf[K, V]) Clone() *MultiMapOf[K, V] {
+	var c = &MultiMapOf[K, V]{}
+	c.t.owner = &c.MapOf
+	m.t.cloneTo(&c.t)
+	return c
+}
+
+// CloneWith is like Clone, but it replace every value of the new map with copyVal(value).
+// O(n)
+func (m *MultiMapOf[K, V]) CloneWith(copyVal func(val V) V) *MultiMapOf[K, V] {
+	var c = m.Clone()
+	for n := c.t.begin(); !sameNode(n, c.t.end()); n = c.t.next(n) {
+		p := valOf[V](&c.t.tree, n)
+		*p = copyVal(*p)
+	}
+	return c
+}
+
+// MultiSetOf is the generic version of the not unique Set, it has all the methods of SetOf,
+// and the nodes of it belong to the embedded SetOf.
+type MultiSetOf[T any] struct {
+	SetOf[T]
+}
+
+// NewMultiSetOf return a not unique set with compare func,
+// it panic with ErrNoCompare if compare is nil.
+func NewMultiSetOf[T any](compare func(a, b T) int) *MultiSetOf[T] {
+	var s = &MultiSetOf[T]{}
+	s.Init(compare)
+	return s
+}
+
+// Init init the set, function NewMultiSetOf will calle it,
+// only the first call of this function will have an affect on set,
+// it panic with ErrNoCompare if compare is nil.
+func (s *MultiSetOf[T]) Init(compare func(a, b T) int) {
+	s.SetOf.Init(false, compare)
+}
+
+// Clone return a new set which has the same compare func,
+// max span and data with s, but it doesn't share memory with s.
+// O(n)
+func (s *MultiSetOf[T]) Clone() *MultiSetOf[T] {
+	var c = &MultiSetOf[T]{}
+	c.t.owner = &c.SetOf
+	s.t.cloneTo(&c.t)
+	return c
+}
@@ -0,0 +1,18 @@
+//go:build go1.18 && gc && !rbtree_safe
+// +build go1.18,gc,!rbtree_safe
+
+package rbtree
+
+func keyOf[K any](t *tree, n node) *K {
+	if reflectOnly {
+		return t.getValueOfKey(n).Addr().Interface().(*K)
+	}
+	return (*K)(arrayAt(t.spans[n.i].keyArrayPtr, int(n.j), t.keySize))
+}
+
+func valOf[V any](t *tree, n node) *V {
+	if reflectOnly {
+		return t.getValueOfVal(n).Addr().Interface().(*V)
+	}
+	return (*V)(arrayAt(t.spans[n.i].valArrayPtr, int(n.j), t.valSize))
+}
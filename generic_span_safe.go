@@ -0,0 +1,13 @@
+//go:build go1.18 && (!gc || rbtree_safe)
+// +build go1.18
+// +build !gc rbtree_safe
+
+package rbtree
+
+func keyOf[K any](t *tree, n node) *K {
+	return t.getValueOfKey(n).Addr().Interface().(*K)
+}
+
+func valOf[V any](t *tree, n node) *V {
+	return t.getValueOfVal(n).Addr().Interface().(*V)
+}
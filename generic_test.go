@@ -0,0 +1,226 @@
+//go:build go1.18
+// +build go1.18
+
+package rbtree
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/cdongyang/library/randint"
+)
+
+func compareIntOf(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Check check the tree by tree.Check with the typed compare func,
+// the key type of tree is set for getKey, which is not used by treeOf.
+func (t *treeOf[K]) Check() (int, int) {
+	var zero K
+	t.keyT = typeOf(zero)
+	t.indirectkey = isDirectIface(t.keyT)
+	defer func(compare func(a, b interface{}) int) {
+		t.tree.compare = compare
+	}(t.tree.compare)
+	t.tree.compare = func(a, b interface{}) int {
+		return t.compare(a.(K), b.(K))
+	}
+	return t.tree.Check()
+}
+
+func testMapOf(t *testing.T, length int, unique bool) {
+	var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+	var max = rand.Int()%length + 1
+	var intSlice = make([]int, length)
+	for i := range intSlice {
+		intSlice[i] = (rand.Int() % max) + 1
+	}
+	var m *MapOf[int, string]
+	if unique {
+		m = NewMapOf[int, string](compareIntOf)
+	} else {
+		m = &NewMultiMapOf[int, string](compareIntOf).MapOf
+	}
+	var count = make(map[int]int)
+	for _, val := range intSlice {
+		n, ok := m.Insert(val, strconv.Itoa(val))
+		if m.Unique() && ok == (count[val] != 0) || !m.Unique() && !ok {
+			t.Fatal("insert error", ok, count[val], val)
+		}
+		if n.GetKey() != val || n.GetVal() != strconv.Itoa(val) {
+			t.Fatal("insert node error", n.GetKey(), n.GetVal(), val)
+		}
+		if m.Unique() {
+			count[val] = 1
+		} else {
+			count[val]++
+		}
+		if m.Count(val) != count[val] {
+			t.Fatal("count error", m.Count(val), count[val])
+		}
+		if _, size := m.t.Check(); size != m.Size() {
+			t.Fatal("size error", size, m.Size())
+		}
+	}
+	var sortSlice = make([]int, 0, len(count))
+	for key := range count {
+		for i := 0; i < count[key]; i++ {
+			sortSlice = append(sortSlice, key)
+		}
+	}
+	sort.Ints(sortSlice)
+	var i int
+	for it := m.Begin(); it != m.End(); it = it.Next() {
+		if key, val := it.GetData(); key != sortSlice[i] || val != strconv.Itoa(sortSlice[i]) {
+			t.Fatal("go through error", key, val, sortSlice[i])
+		}
+		i++
+	}
+	if i != len(sortSlice) {
+		t.Fatal("size error", i, len(sortSlice))
+	}
+	for key := 0; key <= max+1; key++ {
+		lower, upper := m.EqualRange(key)
+		index := sort.SearchInts(sortSlice, key)
+		if index == len(sortSlice) && lower != m.End() || index < len(sortSlice) && lower.GetKey() != sortSlice[index] {
+			t.Fatal("LowerBound error", key)
+		}
+		index = sort.SearchInts(sortSlice, key+1)
+		if index == len(sortSlice) && upper != m.End() || index < len(sortSlice) && upper.GetKey() != sortSlice[index] {
+			t.Fatal("UpperBound error", key)
+		}
+		if it := m.Find(key); count[key] == 0 && it != m.End() || count[key] != 0 && it.GetKey() != key {
+			t.Fatal("Find error", key)
+		}
+	}
+	m.Find(intSlice[0]).SetVal("set")
+	if m.Find(intSlice[0]).GetVal() != "set" {
+		t.Fatal("SetVal error")
+	}
+	for _, val := range intSlice {
+		if num := m.Erase(val); num != count[val] {
+			t.Fatal("erase error", num, count[val], val)
+		}
+		delete(count, val)
+		if _, size := m.t.Check(); size != m.Size() {
+			t.Fatal("size error", size, m.Size())
+		}
+	}
+	if !m.Empty() {
+		t.Fatal("empty error")
+	}
+}
+
+func TestMapOf(t *testing.T) {
+	var testN = 200
+	t.Run("unique", func(t *testing.T) {
+		for i := 0; i < testN; i++ {
+			testMapOf(t, i+1, true)
+		}
+	})
+	t.Run("not unique", func(t *testing.T) {
+		for i := 0; i < testN; i++ {
+			testMapOf(t, i+1, false)
+		}
+	})
+	t.Run("unique 1e4", func(t *testing.T) {
+		testMapOf(t, 1e4, true)
+	})
+	t.Run("not unique 1e4", func(t *testing.T) {
+		testMapOf(t, 1e4, false)
+	})
+}
+
+func TestSetOf(t *testing.T) {
+	var slice = []int{1, 4, 6, 5, 3, 7, 2, 9, 4}
+	s := NewMultiSetOf(compareIntOf)
+	for _, val := range slice {
+		s.Insert(val)
+	}
+	sort.Ints(slice)
+	var i int
+	for it := s.Begin(); it != s.End(); it = it.Next() {
+		if it.GetData() != slice[i] {
+			t.Fatal(it.GetData(), slice[i])
+		}
+		i++
+	}
+	if s.Count(4) != 2 || s.Erase(4) != 2 || s.Size() != len(slice)-2 {
+		t.Fatal("count or erase error", s.Count(4), s.Size())
+	}
+	beg, end := s.EqualRange(5)
+	if s.EraseNodeRange(beg, end) != 1 || s.Find(5) != s.End() {
+		t.Fatal("EraseNodeRange error")
+	}
+	s.EraseNode(s.Begin())
+	if s.Begin().GetData() != 2 || s.Begin().GetSet() != &s.SetOf {
+		t.Fatal("EraseNode error", s.Begin().GetData())
+	}
+}
+
+func TestNewOfNoCompare(t *testing.T) {
+	for _, f := range []func(){
+		func() { NewMapOf[int, int](nil) },
+		func() { NewMultiMapOf[int, int](nil) },
+		func() { NewSetOf[int](nil) },
+		func() { NewMultiSetOf[int](nil) },
+	} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); err != ErrNoCompare {
+					t.Fatal("nil compare should panic with ErrNoCompare", err)
+				}
+			}()
+			f()
+		}()
+	}
+	if NewMultiSetOf(compareIntOf).Unique() || !NewSetOf(compareIntOf).Unique() {
+		t.Fatal("unique error")
+	}
+	var m = NewMultiMapOf[int, string](compareIntOf)
+	m.Insert(1, "a")
+	m.Insert(1, "b")
+	var c = m.CloneWith(func(val string) string { return val + val })
+	if c.Count(1) != 2 || c.Unique() || c.Begin().GetMap() != &c.MapOf || c.Begin().GetVal()+c.Begin().Next().GetVal() != "bbaa" {
+		t.Fatal("MultiMapOf clone error")
+	}
+	var s = NewMultiSetOf(compareIntOf)
+	s.Insert(1)
+	s.Insert(1)
+	if cs := s.Clone(); cs.Count(1) != 2 || cs.Unique() || cs.End().GetSet() != &cs.SetOf {
+		t.Fatal("MultiSetOf clone error")
+	}
+}
+
+func TestTreeOfNoAlloc(t *testing.T) {
+	var x = 1
+	s := NewSetOf(compareIntOf)
+	n := testing.AllocsPerRun(1000, func() {
+		s.Insert(x)
+		x++
+	})
+	if n > 0 {
+		t.Fatal("insert alloc", n)
+	}
+	n = testing.AllocsPerRun(1000, func() {
+		s.Find(x % 1000)
+		s.LowerBound(x % 1000)
+	})
+	if n > 0 {
+		t.Fatal("find alloc", n)
+	}
+	n = testing.AllocsPerRun(1000, func() {
+		s.Erase(x)
+		x--
+	})
+	if n > 0 {
+		t.Fatal("erase alloc", n)
+	}
+}
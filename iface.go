@@ -0,0 +1,19 @@
+package rbtree
+
+import (
+	"reflect"
+)
+
+// directIfaceType report whether the value of typ is stored directly in interface,
+// it's decided by the kind of typ like the compiler.
+func directIfaceType(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Chan, reflect.Map, reflect.Func, reflect.UnsafePointer:
+		return true
+	case reflect.Array:
+		return typ.Len() == 1 && directIfaceType(typ.Elem())
+	case reflect.Struct:
+		return typ.NumField() == 1 && directIfaceType(typ.Field(0).Type)
+	}
+	return false
+}
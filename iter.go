@@ -0,0 +1,85 @@
+//go:build go1.23
+// +build go1.23
+
+package rbtree
+
+import (
+	"iter"
+)
+
+// All return an iterator over the key and value of map in ascending order.
+// the key and value are copies, so they are not changed when the loop body erase or modify the map.
+// the loop body can erase the current key, and then the iteration continue with the key after it.
+func (s *Map) All() iter.Seq2[interface{}, interface{}] {
+	return func(yield func(key, val interface{}) bool) {
+		s.tree.walkData(s.tree.begin(), false, yield)
+	}
+}
+
+// Keys return an iterator over the key of map in ascending order, see All.
+func (s *Map) Keys() iter.Seq[interface{}] {
+	return func(yield func(key interface{}) bool) {
+		s.tree.walkData(s.tree.begin(), false, func(key, _ interface{}) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Values return an iterator over the value of map in ascending order of key, see All.
+func (s *Map) Values() iter.Seq[interface{}] {
+	return func(yield func(val interface{}) bool) {
+		s.tree.walkData(s.tree.begin(), false, func(_, val interface{}) bool {
+			return yield(val)
+		})
+	}
+}
+
+// Backward return an iterator over the key and value of map in descending order, see All.
+func (s *Map) Backward() iter.Seq2[interface{}, interface{}] {
+	return func(yield func(key, val interface{}) bool) {
+		s.tree.walkData(s.tree.most(1), true, yield)
+	}
+}
+
+// Range return an iterator over the key and value of map whose key is in range [lo, hi)
+// in ascending order, see All.
+func (s *Map) Range(lo, hi interface{}) iter.Seq2[interface{}, interface{}] {
+	s.tree.mustCheckKey(lo)
+	s.tree.mustCheckKey(hi)
+	return func(yield func(key, val interface{}) bool) {
+		s.tree.walkData(s.tree.lowerBound(lo), false, func(key, val interface{}) bool {
+			return s.tree.compare(key, hi) < 0 && yield(key, val)
+		})
+	}
+}
+
+// All return an iterator over the data of set in ascending order.
+// the data is a copy, so it's not changed when the loop body erase or modify the set.
+// the loop body can erase the current data, and then the iteration continue with the data after it.
+func (s *Set) All() iter.Seq[interface{}] {
+	return func(yield func(data interface{}) bool) {
+		s.tree.walkData(s.tree.begin(), false, func(key, _ interface{}) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Backward return an iterator over the data of set in descending order, see All.
+func (s *Set) Backward() iter.Seq[interface{}] {
+	return func(yield func(data interface{}) bool) {
+		s.tree.walkData(s.tree.most(1), true, func(key, _ interface{}) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Range return an iterator over the data of set in range [lo, hi) in ascending order, see All.
+func (s *Set) Range(lo, hi interface{}) iter.Seq[interface{}] {
+	s.tree.mustCheckKey(lo)
+	s.tree.mustCheckKey(hi)
+	return func(yield func(data interface{}) bool) {
+		s.tree.walkData(s.tree.lowerBound(lo), false, func(key, _ interface{}) bool {
+			return s.tree.compare(key, hi) < 0 && yield(key)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+//go:build go1.23
+// +build go1.23
+
+package rbtree_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/cdongyang/rbtree"
+)
+
+func TestMapIter(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", nil)
+	for i := 0; i < 10; i++ {
+		m.Insert(i, strconv.Itoa(i))
+	}
+	var want int
+	for key, val := range m.All() {
+		if key != want || val != strconv.Itoa(want) {
+			t.Fatal("All error", key, val, want)
+		}
+		want++
+	}
+	want = 0
+	for key := range m.Keys() {
+		if key != want {
+			t.Fatal("Keys error", key, want)
+		}
+		want++
+	}
+	want = 0
+	for val := range m.Values() {
+		if val != strconv.Itoa(want) {
+			t.Fatal("Values error", val, want)
+		}
+		want++
+	}
+	want = 9
+	for key := range m.Backward() {
+		if key != want {
+			t.Fatal("Backward error", key, want)
+		}
+		if want--; want < 5 {
+			break
+		}
+	}
+	want = 3
+	for key := range m.Range(3, 7) {
+		if key != want {
+			t.Fatal("Range error", key, want)
+		}
+		want++
+	}
+	if want != 7 {
+		t.Fatal("Range stop error", want)
+	}
+	// erase the current key in loop body
+	for key := range m.All() {
+		if key.(int)%2 == 0 {
+			m.Erase(key)
+		}
+	}
+	for key := range m.Backward() {
+		if key.(int)%3 == 0 {
+			m.Erase(key)
+		}
+	}
+	var keys []int
+	for key := range m.Keys() {
+		keys = append(keys, key.(int))
+	}
+	if len(keys) != 3 || keys[0] != 1 || keys[1] != 5 || keys[2] != 7 {
+		t.Fatal("erase in loop error", keys)
+	}
+	// insert the next key in loop body
+	var count int
+	for key := range m.All() {
+		if key.(int) < 20 {
+			m.Insert(key.(int)+10, "")
+		}
+		count++
+	}
+	if count != 9 {
+		t.Fatal("insert in loop error", count)
+	}
+}
+
+func TestSetIter(t *testing.T) {
+	var s = NewSet(false)
+	for _, val := range []int{3, 1, 2, 2, 5} {
+		s.Insert(val)
+	}
+	var result []int
+	for val := range s.All() {
+		result = append(result, val.(int))
+	}
+	for val := range s.Backward() {
+		result = append(result, val.(int))
+	}
+	for val := range s.Range(2, 5) {
+		result = append(result, val.(int))
+	}
+	var want = []int{1, 2, 2, 3, 5, 5, 3, 2, 2, 1, 2, 2, 3}
+	if len(result) != len(want) {
+		t.Fatal("iter error", result)
+	}
+	for i := range want {
+		if result[i] != want[i] {
+			t.Fatal("iter error", result)
+		}
+	}
+	// erasing both the current data and the next data continue with the data after them
+	result = result[:0]
+	for val := range s.All() {
+		result = append(result, val.(int))
+		s.Erase(val.(int))
+	}
+	if len(result) != 4 || result[1] != 2 || result[2] != 3 || s.Size() != 0 {
+		t.Fatal("erase in loop error", result, s.Size())
+	}
+}
+
+func TestIterEraseEqual(t *testing.T) {
+	// int is stored in the raw memory of span, and string is stored by reflect
+	for _, keys := range [][]interface{}{
+		{1, 2, 2, 2, 3},
+		{"1", "2", "2", "2", "3"},
+	} {
+		for _, reverse := range []bool{false, true} {
+			var s = rbtree.NewMultiSet(keys[0], nil)
+			for _, key := range keys {
+				s.Insert(key)
+			}
+			var seq = s.All()
+			if reverse {
+				seq = s.Backward()
+			}
+			var seen []interface{}
+			for key := range seq {
+				seen = append(seen, key)
+				if key == keys[1] {
+					if s.Erase(key) != 3 {
+						t.Fatal("erase error", key)
+					}
+				}
+			}
+			var want = []interface{}{keys[0], keys[1], keys[4]}
+			if reverse {
+				want[0], want[2] = want[2], want[0]
+			}
+			if len(seen) != len(want) || seen[0] != want[0] || seen[1] != want[1] || seen[2] != want[2] {
+				t.Fatal("iter error", seen, reverse)
+			}
+			if s.Size() != 2 || s.Count(keys[1]) != 0 {
+				t.Fatal("size error", s.Size())
+			}
+		}
+	}
+	// the key of map is a copy after erased
+	var m = rbtree.NewMultiMap(int(0), int(0), nil)
+	for _, key := range []int{1, 2, 2, 3} {
+		m.Insert(key, key*10)
+	}
+	var seen []int
+	for key, val := range m.All() {
+		m.Erase(key)
+		seen = append(seen, key.(int), val.(int))
+	}
+	if len(seen) != 6 || seen[2] != 2 || seen[3] != 20 || m.Size() != 0 {
+		t.Fatal("map iter error", seen)
+	}
+}
@@ -1,11 +1,12 @@
 package rbtree
 
 import (
-	"unsafe"
+	"reflect"
 )
 
 // MapNode is the iterator of Map, but it's not thread safe,
-// if the node was erase from map, calling it's method may panic
+// if the node was erase from map, calling it's method will panic with ErrStaleNode.
+// erasing a node never invalidates the other nodes of map.
 type MapNode struct {
 	n _node
 }
@@ -13,13 +14,13 @@ type MapNode struct {
 // GetKey get the key of MapNode, but you should not hold the return interface{},
 // instead, you should do type assert immediately when after call this method
 func (n MapNode) GetKey() interface{} {
-	return n.n.tree.getKey(n.n.node)
+	return n.n.GetKey()
 }
 
 // GetVal get the value of MapNode, but you should not hold the return interface{},
 // instead, you should do type assert immediately when after call this method
 func (n MapNode) GetVal() interface{} {
-	return n.n.tree.getVal(n.n.node)
+	return n.n.GetVal()
 }
 
 func (n MapNode) GetData() (key, val interface{}) {
@@ -27,7 +28,12 @@ func (n MapNode) GetData() (key, val interface{}) {
 }
 
 func (n MapNode) SetVal(val interface{}) {
-	n.n.tree.setVal(n.n.node, val)
+	n.n.SetVal(val)
+}
+
+// TrySetVal is like SetVal, but it return an error instead of panic.
+func (n MapNode) TrySetVal(val interface{}) error {
+	return n.n.TrySetVal(val)
 }
 
 func (n MapNode) Next() MapNode {
@@ -38,31 +44,187 @@ func (n MapNode) Last() MapNode {
 	return MapNode{n.n.Last()}
 }
 
+// Valid report whether n is a node of map and hasn't been erased,
+// End of map is valid.
+func (n MapNode) Valid() bool {
+	return n.n.Valid()
+}
+
+// HasNext report whether n is valid and n is not End of map.
+func (n MapNode) HasNext() bool {
+	return n.n.HasNext()
+}
+
+// HasLast report whether n is valid and n is not Begin of map.
+func (n MapNode) HasLast() bool {
+	return n.n.HasLast()
+}
+
+// TryNext is like Next, but it return an error instead of panic.
+func (n MapNode) TryNext() (MapNode, error) {
+	next, err := n.n.TryNext()
+	return MapNode{next}, err
+}
+
+// TryLast is like Last, but it return an error instead of panic.
+func (n MapNode) TryLast() (MapNode, error) {
+	last, err := n.n.TryLast()
+	return MapNode{last}, err
+}
+
+// GetMap return the Map that current node belong to, or nil if n is the zero value.
 func (n MapNode) GetMap() *Map {
-	return (*Map)(unsafe.Pointer(n.n.tree))
+	if n.n.tree == nil {
+		return nil
+	}
+	m, _ := n.n.tree.owner.(*Map)
+	return m
+}
+
+// NodeHandle is an entry extracted from map by Extract, it own the key and value
+// which are still stored in the span of the map, so that it can be inserted back to
+// the map by InsertHandle without converting them to interface{}.
+// use Merge to move entries to another map without copying.
+// the zero value of NodeHandle is empty, and it become empty after inserted or released.
+// if a NodeHandle is never inserted, call Release to return it's memory to the map,
+// otherwise it's not reused until the map is compacted.
+type NodeHandle struct {
+	n _node
+}
+
+// Empty report whether h has no entry.
+func (h NodeHandle) Empty() bool {
+	return h.n.tree.validNode(h.n) != nil
+}
+
+// GetKey get the key of NodeHandle, it panic if h is empty,
+// you should do type assert immediately like MapNode.GetKey.
+func (h NodeHandle) GetKey() interface{} {
+	return h.n.GetKey()
+}
+
+// GetVal get the value of NodeHandle, it panic if h is empty,
+// you should do type assert immediately like MapNode.GetVal.
+func (h NodeHandle) GetVal() interface{} {
+	return h.n.GetVal()
+}
+
+// SetVal set the value of NodeHandle, it panic if h is empty.
+func (h NodeHandle) SetVal(val interface{}) {
+	h.n.SetVal(val)
+}
+
+// Release drop the entry of h and return it's memory to the map it extracted from,
+// and then h become empty. it does nothing if h is empty.
+func (h NodeHandle) Release() {
+	if !h.Empty() {
+		h.n.tree.freeNode(h.n.node)
+	}
+}
+
+// ReverseMapNode is the reverse iterator of Map, Next of it go to the smaller node,
+// it begin at Map.RBegin() and end at Map.REnd().
+// it point to the same node as MapNode returned by Base, unlike reverse_iterator of C++.
+type ReverseMapNode struct {
+	n _node
+}
+
+// GetKey get the key of ReverseMapNode, see MapNode.GetKey.
+func (n ReverseMapNode) GetKey() interface{} {
+	return n.n.GetKey()
+}
+
+// GetVal get the value of ReverseMapNode, see MapNode.GetVal.
+func (n ReverseMapNode) GetVal() interface{} {
+	return n.n.GetVal()
+}
+
+func (n ReverseMapNode) GetData() (key, val interface{}) {
+	return n.GetKey(), n.GetVal()
+}
+
+func (n ReverseMapNode) SetVal(val interface{}) {
+	n.n.SetVal(val)
+}
+
+// Next return the next node of current node in reverse order,
+// Next of the smallest node is REnd().
+// it will panic if current node equal to map.REnd().
+func (n ReverseMapNode) Next() ReverseMapNode {
+	return ReverseMapNode{n.n.tree.reverseNextNode(n.n)}
+}
+
+// Last return the last node of current node in reverse order,
+// Last of REnd() is the smallest node.
+// it will panic if current node equal to map.RBegin().
+func (n ReverseMapNode) Last() ReverseMapNode {
+	return ReverseMapNode{n.n.tree.reverseLastNode(n.n)}
+}
+
+// Valid report whether n is a node of map and hasn't been erased,
+// REnd of map is valid.
+func (n ReverseMapNode) Valid() bool {
+	return n.n.Valid()
+}
+
+// Base return the MapNode which point to the same node as n,
+// Base of REnd() is End().
+func (n ReverseMapNode) Base() MapNode {
+	return MapNode{n.n}
 }
 
 type Map struct {
 	tree
 }
 
-func NewMap(key, val interface{}, compare func(a, b interface{}) int) *Map {
+// newMap return a Map which is not inited, it's the owner of it's tree
+func newMap() *Map {
 	var m = &Map{}
+	m.tree.owner = m
+	return m
+}
+
+// NewMap return a unique map with key type, value type and compare func,
+// if compare is nil, it use the builtin compare func of key type, see comparator.Builtin.
+func NewMap(key, val interface{}, compare func(a, b interface{}) int) *Map {
+	var m = newMap()
 	m.Init(true, key, val, compare)
 	return m
 }
 
+// NewMultiMap return a not unique map with key type, value type and compare func,
+// if compare is nil, it use the builtin compare func of key type, see comparator.Builtin.
 func NewMultiMap(key, val interface{}, compare func(a, b interface{}) int) *Map {
-	var s = &Map{}
+	var s = newMap()
 	s.Init(false, key, val, compare)
 	return s
 }
 
+// NewMapFromSorted return a map with the keys and vals, the key type and value type of map
+// are the element type of slice keys and vals, vals[i] is the value of keys[i].
+// it build a balanced tree in one span directly, which is much faster than inserting keys one by one.
+// it panic with ErrNotSorted if keys is not sorted by compare, or has equal keys when unique is true.
+// O(n)
+func NewMapFromSorted(keys, vals interface{}, compare func(a, b interface{}) int, unique bool) *Map {
+	keySlice, valSlice := reflect.ValueOf(keys), reflect.ValueOf(vals)
+	if keySlice.Kind() != reflect.Slice || valSlice.Kind() != reflect.Slice {
+		panic(ErrNotSlice)
+	}
+	if keySlice.Len() != valSlice.Len() {
+		panic(ErrBadLength)
+	}
+	var m = newMap()
+	m.Init(unique, reflect.Zero(keySlice.Type().Elem()).Interface(), reflect.Zero(valSlice.Type().Elem()).Interface(), compare)
+	m.tree.buildSorted(keySlice, valSlice)
+	return m
+}
+
 func (s *Map) pack(n _node) MapNode {
 	return MapNode{n: n}
 }
 
 func (s *Map) Init(unique bool, key, val interface{}, compare func(a, b interface{}) int) {
+	s.tree.owner = s
 	s.tree.Init(unique, key, val, compare)
 }
 
@@ -74,15 +236,77 @@ func (s *Map) End() MapNode {
 	return s.pack(s.tree.End())
 }
 
+// RBegin return the last node of map as the begin of reverse order,
+// if map is empty, it return map.REnd().
+func (s *Map) RBegin() ReverseMapNode {
+	return ReverseMapNode{s.tree.pack(s.tree.most(1))}
+}
+
+// REnd represent the end of reverse order, it's the same node as End().
+func (s *Map) REnd() ReverseMapNode {
+	return ReverseMapNode{s.tree.End()}
+}
+
 func (s *Map) EqualRange(key interface{}) (beg, end MapNode) {
 	a, b := s.tree.EqualRange(key)
 	return s.pack(a), s.pack(b)
 }
 
+// Min return the key and value of the first node, ok is false if the map is empty.
+// O(1)
+func (s *Map) Min() (key, val interface{}, ok bool) {
+	return s.tree.Min()
+}
+
+// Max return the key and value of the last node, ok is false if the map is empty.
+// O(1)
+func (s *Map) Max() (key, val interface{}, ok bool) {
+	return s.tree.Max()
+}
+
+// PopMin erase the first node and return a copy of its key and value,
+// ok is false if the map is empty.
+// it may compact the map and invalidate the MapNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(n))
+func (s *Map) PopMin() (key, val interface{}, ok bool) {
+	return s.tree.PopMin()
+}
+
+// PopMax erase the last node and return a copy of its key and value,
+// ok is false if the map is empty.
+// it may compact the map and invalidate the MapNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(n))
+func (s *Map) PopMax() (key, val interface{}, ok bool) {
+	return s.tree.PopMax()
+}
+
+// PopMinN erase the first n nodes and return a copy of their keys and values in order,
+// it erase all the nodes if n is greater than the size of map.
+// the map is rebalanced only once.
+// it may compact the map and invalidate the MapNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(size)+n)
+func (s *Map) PopMinN(n int) (keys, vals []interface{}) {
+	return s.tree.PopMinN(n)
+}
+
 func (s *Map) EraseNode(n MapNode) {
 	s.tree.EraseNode(n.n)
 }
 
+// TryEraseNode is like EraseNode, but it return an error instead of panic.
+func (s *Map) TryEraseNode(n MapNode) error {
+	return s.tree.TryEraseNode(n.n)
+}
+
+// EraseRange erase the nodes whose keys are in the range of lo and hi
+// with the bounds kind bound, the zero value HalfOpen is the range [lo, hi).
+// the range is cut off by split and join, so the map is rebalanced only O(log(n)) times.
+// it may compact the map and invalidate the MapNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(n)+k), k is the number of erased nodes
+func (s *Map) EraseRange(lo, hi interface{}, bound Bound) int {
+	return s.tree.EraseRange(lo, hi, bound)
+}
+
 func (s *Map) EraseNodeRange(beg, end MapNode) (count int) {
 	return s.tree.EraseNodeRange(beg.n, end.n)
 }
@@ -96,10 +320,108 @@ func (s *Map) Insert(key interface{}, val interface{}) (MapNode, bool) {
 	return s.pack(n), ok
 }
 
+// Get return the value of key and true if key exist in map,
+// otherwise it return nil and false.
+// you should not hold the return value, see GetVal.
+// O(log(n))
+func (s *Map) Get(key interface{}) (val interface{}, ok bool) {
+	s.tree.mustCheckKey(key)
+	n := s.tree.find(key)
+	if sameNode(n, s.tree.end()) {
+		return nil, false
+	}
+	return s.tree.getVal(n), true
+}
+
+// InsertOrAssign set the value of key to val if key exist in map,
+// otherwise it insert key and val into map.
+// it return the MapNode of key, and true if key is inserted.
+// for not unique map, it assign one of the nodes whose key equal to key.
+// O(log(n))
+func (s *Map) InsertOrAssign(key, val interface{}) (MapNode, bool) {
+	s.mustCheckData(key, val)
+	n, ok := s.tree.insertOrAssign(key, val)
+	return s.pack(s.tree.pack(n)), ok
+}
+
+// LoadOrStore return the existing value of key and true if key exist in map,
+// otherwise it store key and val into map and return val and false.
+// for not unique map, it load one of the nodes whose key equal to key.
+// you should not hold the return value, see GetVal.
+// O(log(n))
+func (s *Map) LoadOrStore(key, val interface{}) (actual interface{}, loaded bool) {
+	s.mustCheckData(key, val)
+	n, loaded := s.tree.loadOrStore(key, val)
+	return s.tree.getVal(n), loaded
+}
+
+// Update call fn with the value of key and true if key exist in map,
+// or nil and false if not exist. if fn return keep as true, the new value
+// is stored to key, which insert key when it doesn't exist,
+// otherwise key is erased from map.
+// it return the MapNode of key, or End() if key is not kept.
+// for not unique map, it update one of the nodes whose key equal to key.
+// fn must not modify the map, and it will panic with ErrBadValue if type of new value is wrong.
+// O(log(n))
+func (s *Map) Update(key interface{}, fn func(old interface{}, exists bool) (new interface{}, keep bool)) MapNode {
+	s.tree.mustCheckKey(key)
+	return s.pack(s.tree.pack(s.tree.update(key, fn)))
+}
+
+func (s *Map) mustCheckData(key, val interface{}) {
+	if err := s.tree.checkKey(key); err != nil {
+		panic(err)
+	}
+	if err := s.tree.checkVal(val); err != nil {
+		panic(err)
+	}
+}
+
+// InsertHint is like Insert, but it insert key as close as possible to the position just before hint,
+// it compare O(1) times instead of O(log(n)) when key belongs just before or after hint, such as
+// inserting sorted keys with the result of last InsertHint as hint, otherwise it's same as Insert.
+// it's still O(log(n)) because the count of the ancestors of new node is updated up to root.
+func (s *Map) InsertHint(hint MapNode, key, val interface{}) (MapNode, bool) {
+	n, ok := s.tree.InsertHint(hint.n, key, val)
+	return s.pack(n), ok
+}
+
 func (s *Map) LowerBound(key interface{}) MapNode {
 	return s.pack(s.tree.LowerBound(key))
 }
 
+// Floor return the last MapNode not greater than key and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Map) Floor(key interface{}) (MapNode, bool) {
+	n, ok := s.tree.Floor(key)
+	return s.pack(n), ok
+}
+
+// Ceiling return the first MapNode not less than key and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Map) Ceiling(key interface{}) (MapNode, bool) {
+	n, ok := s.tree.Ceiling(key)
+	return s.pack(n), ok
+}
+
+// Lower return the last MapNode less than key and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Map) Lower(key interface{}) (MapNode, bool) {
+	n, ok := s.tree.Lower(key)
+	return s.pack(n), ok
+}
+
+// Higher return the first MapNode greater than key and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Map) Higher(key interface{}) (MapNode, bool) {
+	n, ok := s.tree.Higher(key)
+	return s.pack(n), ok
+}
+
 func (s *Map) UpperBound(key interface{}) MapNode {
 	return s.pack(s.tree.UpperBound(key))
 }
@@ -108,6 +430,163 @@ func (s *Map) Count(key interface{}) (count int) {
 	return s.tree.Count(key)
 }
 
+// Erase erase all the nodes whose key equal to key and return the number of erased nodes.
+// it may compact the map and invalidate the MapNode moved by it if auto compaction is set, see SetAutoCompact.
 func (s *Map) Erase(key interface{}) (count int) {
 	return s.tree.Erase(key)
 }
+
+// Clone return a new map which has the same compare func, unique flag,
+// max span and data with s, but it doesn't share memory with s.
+// O(n)
+func (s *Map) Clone() *Map {
+	var m = newMap()
+	s.tree.cloneTo(&m.tree)
+	return m
+}
+
+// CloneWith is like Clone, but it replace every value of the new map with copyVal(value),
+// it's useful when value is pointer and the new map should not share it with s.
+// it panic with ErrBadValue if copyVal return a value whose type is not the value type of map.
+// O(n)
+func (s *Map) CloneWith(copyVal func(val interface{}) interface{}) *Map {
+	var m = s.Clone()
+	for n := m.tree.begin(); !sameNode(n, m.tree.end()); n = m.tree.next(n) {
+		val := copyVal(m.tree.getVal(n))
+		if err := m.tree.checkVal(val); err != nil {
+			panic(err)
+		}
+		m.tree.setVal(n, val)
+	}
+	return m
+}
+
+// Rank return the number of keys less than key in map.
+// O(log(n))
+func (s *Map) Rank(key interface{}) int {
+	return s.tree.Rank(key)
+}
+
+// Select return the MapNode whose index is i, index begin at 0,
+// if i < 0 or i >= Size(), it return End().
+// O(log(n))
+func (s *Map) Select(i int) MapNode {
+	return s.pack(s.tree.Select(i))
+}
+
+// IndexOf return the index of n in map, index of End() is Size().
+// O(log(n))
+func (s *Map) IndexOf(n MapNode) int {
+	return s.tree.IndexOf(n.n)
+}
+
+// CountRange return the number of keys in range [lo, hi).
+// O(log(n))
+func (s *Map) CountRange(lo, hi interface{}) int {
+	return s.tree.CountRange(lo, hi)
+}
+
+// TryInsert is like Insert, but it return ErrBadKey or ErrBadValue
+// instead of panic when the type of key or value is not same with map,
+// and ErrFull when the map is full in fixed capacity mode.
+func (s *Map) TryInsert(key interface{}, val interface{}) (MapNode, bool, error) {
+	n, ok, err := s.tree.TryInsert(key, val)
+	return s.pack(n), ok, err
+}
+
+// TryFind is like Find, but it return ErrBadKey instead of panic.
+func (s *Map) TryFind(key interface{}) (MapNode, error) {
+	n, err := s.tree.TryFind(key)
+	return s.pack(n), err
+}
+
+// TryLowerBound is like LowerBound, but it return ErrBadKey instead of panic.
+func (s *Map) TryLowerBound(key interface{}) (MapNode, error) {
+	n, err := s.tree.TryLowerBound(key)
+	return s.pack(n), err
+}
+
+// TryUpperBound is like UpperBound, but it return ErrBadKey instead of panic.
+func (s *Map) TryUpperBound(key interface{}) (MapNode, error) {
+	n, err := s.tree.TryUpperBound(key)
+	return s.pack(n), err
+}
+
+// TryCount is like Count, but it return ErrBadKey instead of panic.
+func (s *Map) TryCount(key interface{}) (int, error) {
+	return s.tree.TryCount(key)
+}
+
+// TryErase is like Erase, but it return ErrBadKey instead of panic.
+// it may compact the map and invalidate the MapNode moved by it if auto compaction is set, see SetAutoCompact.
+func (s *Map) TryErase(key interface{}) (int, error) {
+	return s.tree.TryErase(key)
+}
+
+// Split split map into left whose keys are less than key and right whose keys are not less than key,
+// and then s become empty. the structural work is O(log(n)) by the join algorithm of red-black tree,
+// the larger one of left and right take over the spans of s, and the smaller one is moved to a new span.
+// all the MapNode of s are invalid after Split.
+// O(log(n)+min(left.Size(), right.Size()))
+func (s *Map) Split(key interface{}) (left, right *Map) {
+	left, right = newMap(), newMap()
+	s.tree.Split(key, &left.tree, &right.tree)
+	return left, right
+}
+
+// Join move all the keys of other to s, and then other become empty.
+// keys of other must be all greater or all less than keys of s, otherwise it panic with ErrOverlap,
+// and other must have the same type and unique flag as s.
+// the spans of other are moved to s instead of inserting keys one by one, so the MapNode of s are still valid,
+// but the MapNode of other are invalid after Join.
+// O(log(n)+other.Size())
+func (s *Map) Join(other *Map) {
+	s.tree.Join(&other.tree)
+}
+
+// Extract unlink n from map and return it as a NodeHandle,
+// the key and value are not copied, and n become invalid.
+// O(log(n))
+func (s *Map) Extract(n MapNode) NodeHandle {
+	s.tree.checkNode(n.n)
+	return NodeHandle{s.tree.extract(n.n.node)}
+}
+
+// InsertHandle link the entry of h back to map without copying, and then h become empty.
+// h must be extracted from s, otherwise it panic with ErrNotInTree,
+// since every map has it's own spans and the entry can't be moved without copying.
+// if map is unique and the key of h has been in map, it return the exist MapNode and false,
+// and h is still not empty. if h is empty, it return End() and false.
+// O(log(n))
+func (s *Map) InsertHandle(h NodeHandle) (MapNode, bool) {
+	n, ok := s.tree.insertExtracted(h.n)
+	return s.pack(n), ok
+}
+
+// Merge move every entry of src whose key is not in s to s like std::map::merge of C++,
+// the entries whose key has been in unique s are left in src.
+// the spans of src are moved to s like Join, so the moved entries are not copied,
+// if the key range of s and src overlap, the entries left in src are copied to new spans of src.
+// the MapNode of s are still valid, but the MapNode and NodeHandle of src are invalid after Merge.
+// O(log(n)+m) without overlap, otherwise O(m*log(n+m))
+func (s *Map) Merge(src *Map) {
+	s.tree.mergeFrom(&src.tree)
+}
+
+// Descend call fn with the nodes whose key is not greater than from in descending order
+// until fn return false. fn can erase the node passed to it, and then it continue with the next smaller node.
+// O(log(n)+k), k is the number of nodes passed to fn
+func (s *Map) Descend(from interface{}, fn func(n MapNode) bool) {
+	s.tree.Descend(from, func(n _node) bool {
+		return fn(s.pack(n))
+	})
+}
+
+// DescendRange call fn with the nodes whose key is in range (lo, hi] in descending order
+// until fn return false. fn can erase the node passed to it, and then it continue with the next smaller node.
+// O(log(n)+k), k is the number of nodes passed to fn
+func (s *Map) DescendRange(hi, lo interface{}, fn func(n MapNode) bool) {
+	s.tree.DescendRange(hi, lo, func(n _node) bool {
+		return fn(s.pack(n))
+	})
+}
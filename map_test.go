@@ -1,6 +1,7 @@
 package rbtree_test
 
 import (
+	"errors"
 	"sort"
 	"strconv"
 	"testing"
@@ -277,3 +278,491 @@ func TestMap(t *testing.T) {
 		testMap(t, 1e4, false)
 	})
 }
+
+func TestMapClone(t *testing.T) {
+	var m = rbtree.NewMap(int(0), []int(nil), rbtree.CompareInt)
+	m.SetMaxSpan(16)
+	for i := 0; i < 100; i++ {
+		m.Insert(i, []int{i})
+	}
+	for i := 0; i < 100; i += 3 {
+		m.Erase(i)
+	}
+	var c = m.Clone()
+	var d = m.CloneWith(func(val interface{}) interface{} {
+		return append([]int(nil), val.([]int)...)
+	})
+	for _, cm := range []*rbtree.Map{c, d} {
+		if cm.Size() != m.Size() || cm.Unique() != m.Unique() || cm.GetMaxSpan() != m.GetMaxSpan() {
+			t.Fatal("clone error", cm.Size(), m.Size())
+		}
+		if _, size := cm.Check(); size != cm.Size() {
+			t.Fatal("size error", size, cm.Size())
+		}
+		for it, it2 := m.Begin(), cm.Begin(); it != m.End(); it, it2 = it.Next(), it2.Next() {
+			if it.GetKey() != it2.GetKey() || it.GetVal().([]int)[0] != it2.GetVal().([]int)[0] {
+				t.Fatal("clone data error", it.GetKey(), it2.GetKey())
+			}
+		}
+	}
+	// modify the origin map should not affect the clone map
+	m.Find(1).GetVal().([]int)[0] = -1
+	m.Find(2).SetVal([]int{-2})
+	m.Erase(4)
+	m.Insert(3, []int{3})
+	if c.Find(4) == c.End() || c.Find(3) != c.End() || c.Find(2).GetVal().([]int)[0] != 2 {
+		t.Fatal("clone share memory")
+	}
+	if c.Find(1).GetVal().([]int)[0] != -1 || d.Find(1).GetVal().([]int)[0] != 1 {
+		t.Fatal("clone with copy value error")
+	}
+	if m.Begin().GetMap() != m || c.Begin().GetMap() != c || d.Begin().GetMap() != d || (rbtree.MapNode{}).GetMap() != nil {
+		t.Fatal("GetMap error")
+	}
+	for i := 100; i < 200; i++ {
+		c.Insert(i, []int{i})
+	}
+	if _, size := c.Check(); size != c.Size() || m.Size() != d.Size() {
+		t.Fatal("size error", size, c.Size())
+	}
+	// copyVal return a value of wrong type
+	func() {
+		defer func() {
+			if err, _ := recover().(error); err != rbtree.ErrBadValue {
+				t.Fatal("CloneWith should panic with ErrBadValue", err)
+			}
+		}()
+		var e = rbtree.NewMap(int(0), int(0), rbtree.CompareInt)
+		e.Insert(1, 1)
+		e.CloneWith(func(val interface{}) interface{} {
+			return "oops"
+		})
+	}()
+}
+
+func TestMapStaleNode(t *testing.T) {
+	var m = rbtree.NewMap(int(0), int(0), rbtree.CompareInt)
+	for i := 0; i < 10; i++ {
+		m.Insert(i, i)
+	}
+	var stale = m.Find(5)
+	m.Erase(5)
+	m.Insert(100, 100)
+	var mustPanic = func(name string, f func()) {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, rbtree.ErrStaleNode) {
+				t.Fatal(name, "should panic with ErrStaleNode, but got", err)
+			}
+		}()
+		f()
+	}
+	mustPanic("GetKey", func() { stale.GetKey() })
+	mustPanic("GetVal", func() { stale.GetVal() })
+	mustPanic("SetVal", func() { stale.SetVal(1) })
+	if m.Find(100).GetVal() != 100 {
+		t.Fatal("stale node change the map")
+	}
+}
+
+func TestMapTypeCheck(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", rbtree.CompareInt)
+	if !m.GetTypeCheck() {
+		t.Fatal("type check should be on by default")
+	}
+	if _, _, err := m.TryInsert(int64(1), "1"); err != rbtree.ErrBadKey {
+		t.Fatal("TryInsert bad key", err)
+	}
+	if _, _, err := m.TryInsert(1, 1); err != rbtree.ErrBadValue {
+		t.Fatal("TryInsert bad value", err)
+	}
+	if _, _, err := m.TryInsert(1, nil); err != rbtree.ErrBadValue {
+		t.Fatal("TryInsert nil value", err)
+	}
+	if _, ok, err := m.TryInsert(1, "1"); !ok || err != nil {
+		t.Fatal("TryInsert", ok, err)
+	}
+	if _, err := m.TryFind(uint(1)); err != rbtree.ErrBadKey {
+		t.Fatal("TryFind bad key", err)
+	}
+	if n, err := m.TryFind(1); err != nil || n.GetVal() != "1" {
+		t.Fatal("TryFind", err)
+	}
+	if _, err := m.TryLowerBound("1"); err != rbtree.ErrBadKey {
+		t.Fatal("TryLowerBound bad key", err)
+	}
+	if _, err := m.TryUpperBound(1.0); err != rbtree.ErrBadKey {
+		t.Fatal("TryUpperBound bad key", err)
+	}
+	if _, err := m.TryCount(int32(1)); err != rbtree.ErrBadKey {
+		t.Fatal("TryCount bad key", err)
+	}
+	if err := m.Find(1).TrySetVal([]byte("2")); err != rbtree.ErrBadValue {
+		t.Fatal("TrySetVal bad value", err)
+	}
+	if _, err := m.TryErase(int8(1)); err != rbtree.ErrBadKey {
+		t.Fatal("TryErase bad key", err)
+	}
+	if n, err := m.TryErase(1); n != 1 || err != nil || m.Size() != 0 {
+		t.Fatal("TryErase", n, err)
+	}
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, rbtree.ErrBadKey) {
+				t.Fatal("Insert bad key should panic with ErrBadKey", err)
+			}
+		}()
+		m.Insert(int64(1), "1")
+	}()
+	m.SetTypeCheck(false)
+	if _, err := m.TryFind(int64(1)); err != nil || m.GetTypeCheck() {
+		t.Fatal("type check is off", err)
+	}
+}
+
+func TestMapUpsert(t *testing.T) {
+	var m = rbtree.NewMap(int(0), int(0), nil)
+	if n, ok := m.InsertOrAssign(1, 10); !ok || n.GetVal() != 10 {
+		t.Fatal("InsertOrAssign insert error", ok)
+	}
+	if n, ok := m.InsertOrAssign(1, 11); ok || n.GetVal() != 11 || m.Size() != 1 {
+		t.Fatal("InsertOrAssign assign error", ok)
+	}
+	if val, loaded := m.LoadOrStore(1, 12); !loaded || val != 11 {
+		t.Fatal("LoadOrStore load error", val, loaded)
+	}
+	if val, loaded := m.LoadOrStore(2, 20); loaded || val != 20 || m.Size() != 2 {
+		t.Fatal("LoadOrStore store error", val, loaded)
+	}
+	if val, ok := m.Get(2); !ok || val != 20 {
+		t.Fatal("Get error", val, ok)
+	}
+	if val, ok := m.Get(3); ok || val != nil {
+		t.Fatal("Get not exist error", val, ok)
+	}
+	// count the words, and erase the key whose count reach 0
+	var inc = func(delta int) func(old interface{}, exists bool) (interface{}, bool) {
+		return func(old interface{}, exists bool) (interface{}, bool) {
+			var count int
+			if exists {
+				count = old.(int)
+			}
+			count += delta
+			return count, count != 0
+		}
+	}
+	for i := 0; i < 100; i++ {
+		m.Update(i%7, inc(1))
+	}
+	for i := 0; i < 100; i++ {
+		if n := m.Update(i%5, inc(-1)); n != m.End() && n.GetKey() != i%5 {
+			t.Fatal("Update return node error", n.GetKey(), i%5)
+		}
+		if _, size := m.Check(); size != m.Size() {
+			t.Fatal("size error", size, m.Size())
+		}
+	}
+	var want = map[int]int{0: 15 - 20, 1: 11 + 15 - 20, 2: 20 + 14 - 20, 3: 14 - 20, 4: 14 - 20, 5: 14, 6: 14}
+	for key, count := range want {
+		if val, ok := m.Get(key); count != 0 && val != count || count == 0 && ok {
+			t.Fatal("Update error", key, val, count)
+		}
+	}
+	if n := m.Update(5, func(old interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	}); n != m.End() || m.Find(5) != m.End() {
+		t.Fatal("Update erase error")
+	}
+	var mustPanic = func(err error, f func()) {
+		defer func() {
+			if e, _ := recover().(error); !errors.Is(e, err) {
+				t.Fatal("should panic with", err, e)
+			}
+		}()
+		f()
+	}
+	mustPanic(rbtree.ErrBadValue, func() { m.InsertOrAssign(1, "1") })
+	mustPanic(rbtree.ErrBadKey, func() { m.LoadOrStore("1", 1) })
+	mustPanic(rbtree.ErrBadKey, func() { m.Get("1") })
+	mustPanic(rbtree.ErrBadValue, func() {
+		m.Update(1, func(interface{}, bool) (interface{}, bool) { return "1", true })
+	})
+}
+
+func TestMapFromSorted(t *testing.T) {
+	var keys = []string{"a", "b", "c", "d", "e"}
+	var vals = []int{1, 2, 3, 4, 5}
+	var m = rbtree.NewMapFromSorted(keys, vals, nil, true)
+	if _, size := m.Check(); size != len(keys) {
+		t.Fatal("size error", size)
+	}
+	for i, key := range keys {
+		if n := m.Find(key); n == m.End() || n.GetVal() != vals[i] {
+			t.Fatal("find error", key)
+		}
+	}
+	if n, ok := m.Insert("bb", 0); !ok || n.Last().GetKey() != "b" || n.Next().GetKey() != "c" {
+		t.Fatal("insert after build error")
+	}
+	defer func() {
+		if err, _ := recover().(error); err != rbtree.ErrBadLength {
+			t.Fatal("should panic with ErrBadLength", err)
+		}
+	}()
+	rbtree.NewMapFromSorted(keys, vals[1:], nil, true)
+}
+
+func TestMapSplitJoin(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", nil)
+	for i := 0; i < 100; i++ {
+		m.Insert(i, strconv.Itoa(i))
+	}
+	// the smaller one is moved to a new span, the larger one take over the spans
+	for _, key := range []int{10, 90} {
+		left, right := m.Split(key)
+		if left.Size() != key || right.Size() != 100-key {
+			t.Fatal("split size error", left.Size(), right.Size())
+		}
+		for _, part := range []*rbtree.Map{left, right} {
+			if _, size := part.Check(); size != part.Size() {
+				t.Fatal("size error", size, part.Size())
+			}
+			for it := part.Begin(); it != part.End(); it = it.Next() {
+				if it.GetVal() != strconv.Itoa(it.GetKey().(int)) {
+					t.Fatal("value error", it.GetKey(), it.GetVal())
+				}
+			}
+		}
+		right.Join(left)
+		m = right
+	}
+	if val, ok := m.Get(50); m.Size() != 100 || !ok || val != "50" {
+		t.Fatal("join error", m.Size(), val)
+	}
+}
+
+func TestMapExtract(t *testing.T) {
+	type big struct {
+		data [64]int
+	}
+	var pending, active = rbtree.NewMap(int(0), big{}, nil), rbtree.NewMap(int(0), big{}, nil)
+	for i := 0; i < 10; i++ {
+		pending.Insert(i, big{[64]int{i}})
+	}
+	var n = pending.Find(3)
+	var h = pending.Extract(n)
+	if h.Empty() || n.Valid() || pending.Size() != 9 || pending.Find(3) != pending.End() {
+		t.Fatal("extract error")
+	}
+	if h.GetKey() != 3 || h.GetVal().(big).data[0] != 3 {
+		t.Fatal("handle data error", h.GetKey())
+	}
+	h.SetVal(big{[64]int{-3}})
+	// insert back to the same map link the node directly
+	if n, ok := pending.InsertHandle(h); !ok || !h.Empty() || n.GetVal().(big).data[0] != -3 || pending.Size() != 10 {
+		t.Fatal("insert handle to the same map error", ok)
+	}
+	if n, ok := pending.InsertHandle(h); ok || n != pending.End() {
+		t.Fatal("insert empty handle error")
+	}
+	var mustPanic = func(err error, f func()) {
+		defer func() {
+			if e, _ := recover().(error); !errors.Is(e, err) {
+				t.Fatal("should panic with", err, e)
+			}
+		}()
+		f()
+	}
+	// a handle can't be inserted to another map, use Merge instead
+	h = pending.Extract(pending.Find(5))
+	mustPanic(rbtree.ErrNotInTree, func() { active.InsertHandle(h) })
+	pending.Insert(5, big{})
+	if n, ok := pending.InsertHandle(h); ok || h.Empty() || n.GetVal().(big).data[0] != 0 {
+		t.Fatal("insert exist key error")
+	}
+	pending.Erase(5)
+	if n, ok := pending.InsertHandle(h); !ok || !h.Empty() || n.GetVal().(big).data[0] != 5 {
+		t.Fatal("insert handle after erase error")
+	}
+	active.Merge(pending)
+	if !pending.Empty() || active.Size() != 10 || active.Find(5).GetVal().(big).data[0] != 5 {
+		t.Fatal("move entries to another map error", active.Size())
+	}
+	for _, m := range []*rbtree.Map{pending, active} {
+		if _, size := m.Check(); size != m.Size() {
+			t.Fatal("size error", size, m.Size())
+		}
+	}
+	pending.Insert(5, big{})
+	// a released handle return it's slot to the map
+	var fixed = rbtree.NewMap(int(0), int(0), nil)
+	fixed.Reserve(8)
+	fixed.SetFixedCapacity(true)
+	for i := 0; ; i++ {
+		if _, _, err := fixed.TryInsert(i, i); err != nil {
+			break
+		}
+	}
+	h = fixed.Extract(fixed.Begin())
+	if _, _, err := fixed.TryInsert(-1, -1); err != rbtree.ErrFull {
+		t.Fatal("extracted slot should not be reused", err)
+	}
+	h.Release()
+	h.Release()
+	if _, ok, err := fixed.TryInsert(-1, -1); !h.Empty() || !ok || err != nil {
+		t.Fatal("release error")
+	}
+	rbtree.NodeHandle{}.Release()
+	mustPanic(rbtree.ErrNotInTree, func() { rbtree.NodeHandle{}.GetKey() })
+	mustPanic(rbtree.ErrEraseEmpty, func() { pending.Extract(pending.End()) })
+	mustPanic(rbtree.ErrNotInTree, func() {
+		rbtree.NewMap(int(0), int(0), nil).InsertHandle(pending.Extract(pending.Begin()))
+	})
+}
+
+func TestMapMerge(t *testing.T) {
+	for _, overlap := range []bool{true, false} {
+		var dst, src = rbtree.NewMap(int(0), "", nil), rbtree.NewMap(int(0), "", nil)
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 || !overlap && i < 50 {
+				dst.Insert(i, "dst")
+			} else if overlap || i >= 50 {
+				src.Insert(i, "src")
+			}
+		}
+		if overlap {
+			src.Insert(10, "src")
+		}
+		var keep, moved = src.Find(10), dst.Find(20)
+		dst.Merge(src)
+		if dst.Size() != 100 || keep.Valid() || !moved.Valid() || moved.GetVal() != "dst" {
+			t.Fatal("merge size error", dst.Size())
+		}
+		if overlap && (src.Size() != 1 || src.Find(10).GetVal() != "src" || dst.Find(10).GetVal() != "dst") {
+			t.Fatal("merge conflict key error", src.Size())
+		}
+		if !overlap && !src.Empty() {
+			t.Fatal("merge without overlap error", src.Size())
+		}
+		for _, m := range []*rbtree.Map{dst, src} {
+			if _, size := m.Check(); size != m.Size() {
+				t.Fatal("size error", size, m.Size())
+			}
+		}
+	}
+}
+
+func TestMapDescend(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", nil)
+	for i := 0; i < 10; i++ {
+		m.Insert(i, strconv.Itoa(i))
+	}
+	var want = 9
+	for it := m.RBegin(); it != m.REnd(); it = it.Next() {
+		if key, val := it.GetData(); key != want || val != strconv.Itoa(want) {
+			t.Fatal("reverse go through error", key, val, want)
+		}
+		it.SetVal("")
+		want--
+	}
+	want = 7
+	m.DescendRange(7, 2, func(n rbtree.MapNode) bool {
+		if n.GetKey() != want || n.GetVal() != "" {
+			t.Fatal("DescendRange error", n.GetKey(), want)
+		}
+		want--
+		return true
+	})
+	if want != 2 {
+		t.Fatal("DescendRange stop error", want)
+	}
+}
+
+func TestMapNearest(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", nil)
+	for i := 0; i < 10; i += 2 {
+		m.Insert(i, strconv.Itoa(i))
+	}
+	for _, c := range []struct {
+		name string
+		f    func(interface{}) (rbtree.MapNode, bool)
+		key  int
+		want int // -1 means no such node
+	}{
+		{"Floor", m.Floor, 5, 4}, {"Floor", m.Floor, 4, 4}, {"Floor", m.Floor, -1, -1},
+		{"Ceiling", m.Ceiling, 5, 6}, {"Ceiling", m.Ceiling, 6, 6}, {"Ceiling", m.Ceiling, 9, -1},
+		{"Lower", m.Lower, 4, 2}, {"Lower", m.Lower, 0, -1}, {"Lower", m.Lower, 100, 8},
+		{"Higher", m.Higher, 4, 6}, {"Higher", m.Higher, 8, -1}, {"Higher", m.Higher, -5, 0},
+	} {
+		n, ok := c.f(c.key)
+		if c.want < 0 && (ok || n != m.End()) || c.want >= 0 && (!ok || n.GetKey() != c.want || n.GetVal() != strconv.Itoa(c.want)) {
+			t.Fatal(c.name, "error", c.key, ok, c.want)
+		}
+	}
+}
+
+func TestMapPop(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", nil)
+	for i := 0; i < 10; i++ {
+		m.Insert(i, strconv.Itoa(i))
+	}
+	if key, val, ok := m.Min(); !ok || key != 0 || val != "0" {
+		t.Fatal("Min error", key, val)
+	}
+	if key, val, ok := m.Max(); !ok || key != 9 || val != "9" {
+		t.Fatal("Max error", key, val)
+	}
+	// the popped key and value are copied, reuse of the freed node must not change them
+	key, val, ok := m.PopMin()
+	m.Insert(100, "100")
+	if !ok || key != 0 || val != "0" || m.Size() != 10 {
+		t.Fatal("PopMin error", key, val)
+	}
+	if key, val, ok := m.PopMax(); !ok || key != 100 || val != "100" {
+		t.Fatal("PopMax error", key, val)
+	}
+	keys, vals := m.PopMinN(3)
+	m.Insert(-1, "-1")
+	for i := range keys {
+		if keys[i] != i+1 || vals[i] != strconv.Itoa(i+1) {
+			t.Fatal("PopMinN error", keys[i], vals[i])
+		}
+	}
+	if len(keys) != 3 || m.Size() != 7 || m.Begin().GetKey() != -1 || m.Begin().Next().GetKey() != 4 {
+		t.Fatal("PopMinN error", len(keys), m.Size())
+	}
+}
+
+func TestMapEraseRange(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", nil)
+	for i := 0; i < 100; i++ {
+		m.Insert(i, strconv.Itoa(i))
+	}
+	// retention: erase the old keys from the front
+	if num := m.EraseRange(-1, 30, rbtree.HalfOpen); num != 30 || m.Begin().GetKey() != 30 {
+		t.Fatal("EraseRange prefix error", num, m.Begin().GetKey())
+	}
+	if num := m.EraseRange(90, 100, rbtree.Closed); num != 10 || m.End().Last().GetKey() != 89 {
+		t.Fatal("EraseRange suffix error", num)
+	}
+	if num := m.EraseRange(40, 50, rbtree.Open); num != 9 || m.Find(40).GetVal() != "40" || m.Find(50).GetVal() != "50" || m.Find(45) != m.End() {
+		t.Fatal("EraseRange middle error", num)
+	}
+	if m.Size() != 51 {
+		t.Fatal("size error", m.Size())
+	}
+}
+
+func TestMapCompact(t *testing.T) {
+	var m = rbtree.NewMap(int(0), "", nil)
+	m.SetAutoCompact(0.5)
+	for i := 0; i < 5000; i++ {
+		m.Insert(i, strconv.Itoa(i))
+	}
+	var n = m.Find(4999)
+	if keys, _ := m.PopMinN(4990); len(keys) != 4990 || n.Valid() {
+		t.Fatal("PopMinN should compact automatically", len(keys))
+	}
+	if m.Size() != 10 || m.Begin().GetKey() != 4990 || m.Find(4995).GetVal() != "4995" {
+		t.Fatal("compact error", m.Size())
+	}
+}
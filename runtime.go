@@ -1,6 +1,10 @@
+//go:build gc && !rbtree_safe
+// +build gc,!rbtree_safe
+
 package rbtree
 
 import (
+	"reflect"
 	"unsafe"
 )
 
@@ -83,12 +87,18 @@ const (
 )
 
 // isDirectIface reports whether t is stored directly in an interface value.
+// the kindDirectIface bit is used only if it's verified at init, see runtime_check.go.
 func isDirectIface(t *_type) bool {
+	if !kindDirectIfaceOK {
+		return directIfaceType(reflect.TypeOf(pack2Iface(t, nil)))
+	}
 	return t.kind&kindDirectIface != 0
 }
 
+// isNoPtrIface reports whether t has no pointers, the kindNoPointers bit is removed
+// in new release of go, so ptrdata is used and it's verified at init.
 func isNoPtrIface(t *_type) bool {
-	return t.kind&kindNoPointers != 0
+	return t.ptrdata == 0
 }
 
 func add(p unsafe.Pointer, x uintptr) unsafe.Pointer {
@@ -100,6 +110,32 @@ func newmem(size uintptr) unsafe.Pointer {
 	return unsafe.Pointer(&bytes[0])
 }
 
+// memcopy copy size bytes from src to dst, the memory must not contain pointer
+func memcopy(dst, src unsafe.Pointer, size uintptr) {
+	const chunk = 1 << 30
+	for size > chunk {
+		copy((*[chunk]byte)(dst)[:], (*[chunk]byte)(src)[:])
+		dst, src, size = add(dst, chunk), add(src, chunk), size-chunk
+	}
+	copy((*[chunk]byte)(dst)[:size:size], (*[chunk]byte)(src)[:size:size])
+}
+
+// memclr clear size bytes from p, the memory must not contain pointer
+func memclr(p unsafe.Pointer, size uintptr) {
+	const chunk = 1 << 30
+	for size > chunk {
+		memclrChunk((*[chunk]byte)(p)[:])
+		p, size = add(p, chunk), size-chunk
+	}
+	memclrChunk((*[chunk]byte)(p)[:size:size])
+}
+
+func memclrChunk(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 // noescape hides a pointer from escape analysis.  noescape is
 // the identity function but escape analysis doesn't think the
 // output depends on the input.  noescape is inlined and currently
@@ -0,0 +1,126 @@
+//go:build gc && !rbtree_safe
+// +build gc,!rbtree_safe
+
+package rbtree
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+var (
+	// kindDirectIfaceOK report whether the kindDirectIface bit of _type.kind is right,
+	// otherwise isDirectIface decide it by reflect.
+	kindDirectIfaceOK = true
+	// noPointersOK report whether _type.ptrdata agree with reflect,
+	// otherwise keys and values are never stored in raw mode.
+	noPointersOK = true
+	// reflectOnly report whether key and value are read and written only by reflect,
+	// it's true if the runtime layout assumed by runtime.go doesn't hold.
+	reflectOnly = false
+)
+
+// the layout of runtime may change in a new release of go,
+// so it's verified before any tree is used.
+func init() {
+	var ok bool
+	if kindDirectIfaceOK, noPointersOK, ok = checkIface(); ok {
+		ok = checkSpans()
+	}
+	reflectOnly = !ok
+}
+
+type layoutSmall struct {
+	a int8
+	b int16
+}
+
+type layoutLarge struct {
+	s string
+	p *int
+	a [3]int
+}
+
+// layoutSamples return values of every kind to check the runtime layout
+func layoutSamples() []interface{} {
+	var x = 1
+	return []interface{}{
+		int(-1), int8(-2), int16(-3), int32(-4), int64(-5),
+		uint(1), uint8(2), uint16(3), uint32(4), uint64(5), uintptr(6),
+		float32(1.5), float64(2.5), complex64(1 + 2i), complex128(3 + 4i),
+		true, "string", []int{1, 2}, map[int]int{1: 2}, make(chan int),
+		&x, unsafe.Pointer(&x), [2]int{1, 2}, [1]*int{&x},
+		layoutSmall{1, 2}, layoutLarge{"s", &x, [3]int{1, 2, 3}}, struct{ p *int }{&x},
+		struct{}{}, [0]int{}, [0]*int{}, [2]layoutSmall{{1, 2}, {3, 4}},
+	}
+}
+
+// checkIface round-trip the samples through unpackIface and pack2Iface,
+// ok is false if eface or _type doesn't have the assumed layout.
+// kindOK is false if the kindDirectIface bit doesn't agree with reflect,
+// and noPtrOK is false if isNoPtrIface doesn't agree with reflect.
+func checkIface() (kindOK, noPtrOK, ok bool) {
+	kindOK, noPtrOK = true, true
+	for _, x := range layoutSamples() {
+		e, typ := unpackIface(x), reflect.TypeOf(x)
+		if e._type.size != typ.Size() || reflect.Kind(e._type.kind&kindMask) != typ.Kind() {
+			return false, false, false
+		}
+		if !reflect.DeepEqual(pack2Iface(e._type, e.p), x) {
+			return false, false, false
+		}
+		if (e._type.kind&kindDirectIface != 0) != directIfaceType(typ) {
+			kindOK = false
+		}
+		if isNoPtrIface(e._type) == hasPointers(typ) {
+			noPtrOK = false
+		}
+	}
+	return kindOK, noPtrOK, true
+}
+
+// hasPointers report whether the value of typ contains pointers, it's decided by reflect.
+func hasPointers(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Chan, reflect.Map, reflect.Func, reflect.UnsafePointer,
+		reflect.Interface, reflect.String, reflect.Slice:
+		return true
+	case reflect.Array:
+		return typ.Len() > 0 && hasPointers(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if hasPointers(typ.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkSpans round-trip the samples through the spans of tree as key and value,
+// it return false if reflect.Value doesn't have the assumed layout.
+func checkSpans() bool {
+	for _, x := range layoutSamples() {
+		if !checkSpan(x) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkSpan(x interface{}) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	var t tree
+	t.Init(false, x, x, func(a, b interface{}) int { return 0 })
+	var keys = t.spans[t.header.i].keys
+	if !reflectOnly && uintptr(getArrayPtrOfSliceValue(keys)) != keys.Pointer() {
+		return false
+	}
+	n, _ := t.insert(x, x)
+	return reflect.DeepEqual(t.getKey(n), x) && reflect.DeepEqual(t.getVal(n), x) &&
+		reflect.DeepEqual(t.getValueOfKey(n).Interface(), x) && reflect.DeepEqual(t.getValueOfVal(n).Interface(), x)
+}
@@ -0,0 +1,134 @@
+//go:build gc && !rbtree_safe
+// +build gc,!rbtree_safe
+
+package rbtree
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestGetArrayPtrOfSliceValue(t *testing.T) {
+	if reflectOnly {
+		t.Skip("the runtime layout is not used")
+	}
+	a := 10
+	atype := reflect.TypeOf(a)
+	avalue := reflect.ValueOf(a)
+	as := reflect.MakeSlice(reflect.SliceOf(atype), 4, 4)
+	asp := getArrayPtrOfSliceValue(as)
+	as.Index(0).Set(avalue)
+	as.Index(2).Set(avalue)
+	t.Log(*(*[4]int)(asp))
+	t.Log(pack2Iface(unpackIface(a)._type, arrayAt(asp, 2, 8)))
+	if *(*int)(asp) != a {
+		t.Logf("%+v\n%+v\n", as, *(*slice)((*eface)(unsafe.Pointer(&as)).p))
+		t.Fatal(*(*int)(asp))
+	}
+	b := &a
+	btype := reflect.TypeOf(b)
+	bvalue := reflect.ValueOf(b)
+	bs := reflect.MakeSlice(reflect.SliceOf(btype), 4, 4)
+	bsp := getArrayPtrOfSliceValue(bs)
+	bs.Index(0).Set(bvalue)
+	bs.Index(2).Set(bvalue)
+	t.Log(*(*[4]unsafe.Pointer)(bsp))
+	t.Log(pack2Iface(unpackIface(b)._type, *(*unsafe.Pointer)(arrayAt(bsp, 2, 8))), b)
+	if **(**int)(bsp) != a {
+		t.Fatal(**(**int)(bsp))
+	}
+	tree := NewTree2(false)
+	beg, ok := tree.Insert("10", b)
+	if !ok {
+		t.Fatal()
+	}
+	if beg.GetKey() != "10" || beg.GetVal() != b {
+		t.Fatal(beg.GetKey(), beg.GetVal())
+	}
+}
+
+func ExampleNoescapeInsert() {
+	var tree = NewTree(false)
+	var rand = benchRand
+	n := testing.AllocsPerRun(1000, func() {
+		_, ok := tree.Insert(noescapeInterface(rand.Int()), nil)
+		if !ok {
+			panic("insert error")
+		}
+	})
+	fmt.Println(n)
+	// Output:
+	//0
+}
+
+func TestCheckLayout(t *testing.T) {
+	t.Log("kindDirectIfaceOK:", kindDirectIfaceOK, "noPointersOK:", noPointersOK, "reflectOnly:", reflectOnly)
+	if _, noPtrOK, ok := checkIface(); ok {
+		for _, x := range layoutSamples() {
+			if isDirectIface(unpackIface(x)._type) != directIfaceType(reflect.TypeOf(x)) {
+				t.Fatal("isDirectIface error", reflect.TypeOf(x))
+			}
+			if noPtrOK && isNoPtrIface(unpackIface(x)._type) == hasPointers(reflect.TypeOf(x)) {
+				t.Fatal("isNoPtrIface error", reflect.TypeOf(x))
+			}
+		}
+	}
+	// the reflect only storage always works, and the other works if the layout is verified
+	var layoutOK = !reflectOnly
+	defer func(old bool) {
+		reflectOnly = old
+	}(reflectOnly)
+	for _, only := range []bool{false, true} {
+		reflectOnly = only
+		if (only || layoutOK) && !checkSpans() {
+			t.Fatal("span round-trip error, reflectOnly:", only)
+		}
+	}
+}
+
+func TestRawMode(t *testing.T) {
+	type point struct{ x, y int32 }
+	var raw = !reflectOnly && noPointersOK
+	for _, c := range []struct {
+		key, val interface{}
+		raw      bool
+	}{
+		{int(0), int64(0), raw}, {point{}, float64(0), raw}, {int(0), nil, raw},
+		{"", int(0), false}, {int(0), &point{}, false}, {struct{}{}, int(0), false},
+	} {
+		var tr tree
+		tr.Init(false, c.key, c.val, func(a, b interface{}) int { return 0 })
+		if tr.raw != c.raw {
+			t.Fatal("raw mode error", reflect.TypeOf(c.key), reflect.TypeOf(c.val), tr.raw)
+		}
+	}
+	m := NewMap(int(0), point{}, CompareInt)
+	m.SetMaxSpan(16)
+	for i := 0; i < 100; i++ {
+		m.Insert(i, point{int32(i), -int32(i)})
+	}
+	for i := 0; i < 100; i += 2 {
+		m.Erase(i)
+	}
+	c := m.Clone()
+	m.Reset()
+	runtime.GC()
+	for i := 0; i < 100; i++ {
+		n := c.Find(i)
+		if (i%2 == 0) != (n == c.End()) {
+			t.Fatal("find error", i)
+		}
+		if i%2 == 1 && n.GetVal() != (point{int32(i), -int32(i)}) {
+			t.Fatal("value error", i, n.GetVal())
+		}
+	}
+	// a freed node is reused with zero value
+	n, _ := m.Insert(1, point{1, 1})
+	m.tree.clearData(n.n.node)
+	if n.GetKey() != 0 || n.GetVal() != (point{}) {
+		t.Fatal("clear error", n.GetKey(), n.GetVal())
+	}
+}
@@ -1,11 +1,12 @@
 package rbtree
 
 import (
-	"unsafe"
+	"reflect"
 )
 
 // SetNode is the iterator of set, but it's not thread safe,
-// if the node was erase from set, calling it's method may panic
+// if the node was erase from set, calling it's method will panic with ErrStaleNode.
+// erasing a node never invalidates the other nodes of set.
 type SetNode struct {
 	n _node
 }
@@ -13,7 +14,7 @@ type SetNode struct {
 // GetData get the data of SetNode, but you should not hold the return interface{},
 // instead, you should do type assert immediately when after call this method
 func (n SetNode) GetData() interface{} {
-	return n.n.tree.getKey(n.n.node)
+	return n.n.GetKey()
 }
 
 // Next return the next node of current node.
@@ -28,31 +29,125 @@ func (n SetNode) Last() SetNode {
 	return SetNode{n.n.Last()}
 }
 
-// GetSet return the Set that current node belong to.
+// Valid report whether n is a node of set and hasn't been erased,
+// End of set is valid.
+func (n SetNode) Valid() bool {
+	return n.n.Valid()
+}
+
+// HasNext report whether n is valid and n is not End of set.
+func (n SetNode) HasNext() bool {
+	return n.n.HasNext()
+}
+
+// HasLast report whether n is valid and n is not Begin of set.
+func (n SetNode) HasLast() bool {
+	return n.n.HasLast()
+}
+
+// TryNext is like Next, but it return an error instead of panic.
+func (n SetNode) TryNext() (SetNode, error) {
+	next, err := n.n.TryNext()
+	return SetNode{next}, err
+}
+
+// TryLast is like Last, but it return an error instead of panic.
+func (n SetNode) TryLast() (SetNode, error) {
+	last, err := n.n.TryLast()
+	return SetNode{last}, err
+}
+
+// GetSet return the Set that current node belong to, or nil if n is the zero value.
 func (n SetNode) GetSet() *Set {
-	return (*Set)(unsafe.Pointer(n.n.tree))
+	if n.n.tree == nil {
+		return nil
+	}
+	s, _ := n.n.tree.owner.(*Set)
+	return s
+}
+
+// ReverseSetNode is the reverse iterator of Set, Next of it go to the smaller node,
+// it begin at Set.RBegin() and end at Set.REnd().
+// it point to the same node as SetNode returned by Base, unlike reverse_iterator of C++.
+type ReverseSetNode struct {
+	n _node
+}
+
+// GetData get the data of ReverseSetNode, see SetNode.GetData.
+func (n ReverseSetNode) GetData() interface{} {
+	return n.n.GetKey()
+}
+
+// Next return the next node of current node in reverse order,
+// Next of the smallest node is REnd().
+// it will panic if current node equal to set.REnd().
+func (n ReverseSetNode) Next() ReverseSetNode {
+	return ReverseSetNode{n.n.tree.reverseNextNode(n.n)}
+}
+
+// Last return the last node of current node in reverse order,
+// Last of REnd() is the smallest node.
+// it will panic if current node equal to set.RBegin().
+func (n ReverseSetNode) Last() ReverseSetNode {
+	return ReverseSetNode{n.n.tree.reverseLastNode(n.n)}
+}
+
+// Valid report whether n is a node of set and hasn't been erased,
+// REnd of set is valid.
+func (n ReverseSetNode) Valid() bool {
+	return n.n.Valid()
+}
+
+// Base return the SetNode which point to the same node as n,
+// Base of REnd() is End().
+func (n ReverseSetNode) Base() SetNode {
+	return SetNode{n.n}
 }
 
 type Set struct {
 	tree
 }
 
+// newSet return a Set which is not inited, it's the owner of it's tree
+func newSet() *Set {
+	var s = &Set{}
+	s.tree.owner = s
+	return s
+}
+
 // NewSet return a unique set with data type and compare func,
 // the return set has been executed init func.
+// if compare is nil, it use the builtin compare func of data type, see comparator.Builtin.
 func NewSet(data interface{}, compare func(a, b interface{}) int) *Set {
-	var s = &Set{}
+	var s = newSet()
 	s.Init(true, data, compare)
 	return s
 }
 
 // NewSet return a not unique set with data type and compare func,
 // the return set has been executed init func.
+// if compare is nil, it use the builtin compare func of data type, see comparator.Builtin.
 func NewMultiSet(data interface{}, compare func(a, b interface{}) int) *Set {
-	var s = &Set{}
+	var s = newSet()
 	s.Init(false, data, compare)
 	return s
 }
 
+// NewSetFromSorted return a set with the data of slice data, the data type of set is the element type of data.
+// it build a balanced tree in one span directly, which is much faster than inserting data one by one.
+// it panic with ErrNotSorted if data is not sorted by compare, or has equal data when unique is true.
+// O(n)
+func NewSetFromSorted(data interface{}, compare func(a, b interface{}) int, unique bool) *Set {
+	slice := reflect.ValueOf(data)
+	if slice.Kind() != reflect.Slice {
+		panic(ErrNotSlice)
+	}
+	var s = newSet()
+	s.Init(unique, reflect.Zero(slice.Type().Elem()).Interface(), compare)
+	s.tree.buildSorted(slice, reflect.Value{})
+	return s
+}
+
 func (s *Set) pack(n _node) SetNode {
 	return SetNode{n: n}
 }
@@ -60,6 +155,7 @@ func (s *Set) pack(n _node) SetNode {
 // Init init the set, function NewSet and NewMultiSet will calle it,
 // only the first call of this function will have an affect on set
 func (s *Set) Init(unique bool, data interface{}, compare func(a, b interface{}) int) {
+	s.tree.owner = s
 	s.tree.Init(unique, data, nil, compare)
 }
 
@@ -74,17 +170,84 @@ func (s *Set) End() SetNode {
 	return s.pack(s.tree.End())
 }
 
+// RBegin return the last node of set as the begin of reverse order,
+// if set is empty, it return set.REnd().
+func (s *Set) RBegin() ReverseSetNode {
+	return ReverseSetNode{s.tree.pack(s.tree.most(1))}
+}
+
+// REnd represent the end of reverse order, it's the same node as End().
+func (s *Set) REnd() ReverseSetNode {
+	return ReverseSetNode{s.tree.End()}
+}
+
 func (s *Set) EqualRange(data interface{}) (beg, end SetNode) {
 	a, b := s.tree.EqualRange(data)
 	return s.pack(a), s.pack(b)
 }
 
+// Min return the first data, ok is false if the set is empty.
+// O(1)
+func (s *Set) Min() (data interface{}, ok bool) {
+	data, _, ok = s.tree.Min()
+	return data, ok
+}
+
+// Max return the last data, ok is false if the set is empty.
+// O(1)
+func (s *Set) Max() (data interface{}, ok bool) {
+	data, _, ok = s.tree.Max()
+	return data, ok
+}
+
+// PopMin erase the first node and return a copy of its data,
+// ok is false if the set is empty.
+// it may compact the set and invalidate the SetNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(n))
+func (s *Set) PopMin() (data interface{}, ok bool) {
+	data, _, ok = s.tree.PopMin()
+	return data, ok
+}
+
+// PopMax erase the last node and return a copy of its data,
+// ok is false if the set is empty.
+// it may compact the set and invalidate the SetNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(n))
+func (s *Set) PopMax() (data interface{}, ok bool) {
+	data, _, ok = s.tree.PopMax()
+	return data, ok
+}
+
+// PopMinN erase the first n nodes and return a copy of their data in order,
+// it erase all the nodes if n is greater than the size of set.
+// the set is rebalanced only once.
+// it may compact the set and invalidate the SetNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(size)+n)
+func (s *Set) PopMinN(n int) []interface{} {
+	data, _ := s.tree.PopMinN(n)
+	return data
+}
+
 // EraseNode erase a SetNode from tree,
 // if SetNode has been erased, calling will panic
 func (s *Set) EraseNode(n SetNode) {
 	s.tree.EraseNode(n.n)
 }
 
+// TryEraseNode is like EraseNode, but it return an error instead of panic.
+func (s *Set) TryEraseNode(n SetNode) error {
+	return s.tree.TryEraseNode(n.n)
+}
+
+// EraseRange erase the nodes whose keys are in the range of lo and hi
+// with the bounds kind bound, the zero value HalfOpen is the range [lo, hi).
+// the range is cut off by split and join, so the set is rebalanced only O(log(n)) times.
+// it may compact the set and invalidate the SetNode moved by it if auto compaction is set, see SetAutoCompact.
+// O(log(n)+k), k is the number of erased nodes
+func (s *Set) EraseRange(lo, hi interface{}, bound Bound) int {
+	return s.tree.EraseRange(lo, hi, bound)
+}
+
 func (s *Set) EraseNodeRange(beg, end SetNode) (count int) {
 	return s.tree.EraseNodeRange(beg.n, end.n)
 }
@@ -98,10 +261,51 @@ func (s *Set) Insert(data interface{}) (SetNode, bool) {
 	return s.pack(n), ok
 }
 
+// InsertHint is like Insert, but it insert data as close as possible to the position just before hint,
+// it compare O(1) times instead of O(log(n)) when data belongs just before or after hint, such as
+// inserting sorted data with the result of last InsertHint as hint, otherwise it's same as Insert.
+// it's still O(log(n)) because the count of the ancestors of new node is updated up to root.
+func (s *Set) InsertHint(hint SetNode, data interface{}) (SetNode, bool) {
+	n, ok := s.tree.InsertHint(hint.n, data, nil)
+	return s.pack(n), ok
+}
+
 func (s *Set) LowerBound(data interface{}) SetNode {
 	return s.pack(s.tree.LowerBound(data))
 }
 
+// Floor return the last SetNode not greater than data and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Set) Floor(data interface{}) (SetNode, bool) {
+	n, ok := s.tree.Floor(data)
+	return s.pack(n), ok
+}
+
+// Ceiling return the first SetNode not less than data and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Set) Ceiling(data interface{}) (SetNode, bool) {
+	n, ok := s.tree.Ceiling(data)
+	return s.pack(n), ok
+}
+
+// Lower return the last SetNode less than data and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Set) Lower(data interface{}) (SetNode, bool) {
+	n, ok := s.tree.Lower(data)
+	return s.pack(n), ok
+}
+
+// Higher return the first SetNode greater than data and true,
+// or End() and false if there is no such node or the type of key is wrong, it never panic.
+// O(log(n))
+func (s *Set) Higher(data interface{}) (SetNode, bool) {
+	n, ok := s.tree.Higher(data)
+	return s.pack(n), ok
+}
+
 func (s *Set) UpperBound(data interface{}) SetNode {
 	return s.pack(s.tree.UpperBound(data))
 }
@@ -110,6 +314,175 @@ func (s *Set) Count(data interface{}) (count int) {
 	return s.tree.Count(data)
 }
 
+// Erase erase all the nodes whose data equal to data and return the number of erased nodes.
+// it may compact the set and invalidate the SetNode moved by it if auto compaction is set, see SetAutoCompact.
 func (s *Set) Erase(data interface{}) (count int) {
 	return s.tree.Erase(data)
 }
+
+// Clone return a new set which has the same compare func, unique flag,
+// max span and data with s, but it doesn't share memory with s.
+// O(n)
+func (s *Set) Clone() *Set {
+	var c = newSet()
+	s.tree.cloneTo(&c.tree)
+	return c
+}
+
+// Rank return the number of data less than data in set.
+// O(log(n))
+func (s *Set) Rank(data interface{}) int {
+	return s.tree.Rank(data)
+}
+
+// Select return the SetNode whose index is i, index begin at 0,
+// if i < 0 or i >= Size(), it return End().
+// O(log(n))
+func (s *Set) Select(i int) SetNode {
+	return s.pack(s.tree.Select(i))
+}
+
+// IndexOf return the index of n in set, index of End() is Size().
+// O(log(n))
+func (s *Set) IndexOf(n SetNode) int {
+	return s.tree.IndexOf(n.n)
+}
+
+// CountRange return the number of data in range [lo, hi).
+// O(log(n))
+func (s *Set) CountRange(lo, hi interface{}) int {
+	return s.tree.CountRange(lo, hi)
+}
+
+// TryInsert is like Insert, but it return ErrBadKey
+// instead of panic when the type of data is not same with set,
+// and ErrFull when the set is full in fixed capacity mode.
+func (s *Set) TryInsert(data interface{}) (SetNode, bool, error) {
+	n, ok, err := s.tree.TryInsert(data, nil)
+	return s.pack(n), ok, err
+}
+
+// TryFind is like Find, but it return ErrBadKey instead of panic.
+func (s *Set) TryFind(data interface{}) (SetNode, error) {
+	n, err := s.tree.TryFind(data)
+	return s.pack(n), err
+}
+
+// TryLowerBound is like LowerBound, but it return ErrBadKey instead of panic.
+func (s *Set) TryLowerBound(data interface{}) (SetNode, error) {
+	n, err := s.tree.TryLowerBound(data)
+	return s.pack(n), err
+}
+
+// TryUpperBound is like UpperBound, but it return ErrBadKey instead of panic.
+func (s *Set) TryUpperBound(data interface{}) (SetNode, error) {
+	n, err := s.tree.TryUpperBound(data)
+	return s.pack(n), err
+}
+
+// TryCount is like Count, but it return ErrBadKey instead of panic.
+func (s *Set) TryCount(data interface{}) (int, error) {
+	return s.tree.TryCount(data)
+}
+
+// TryErase is like Erase, but it return ErrBadKey instead of panic.
+// it may compact the set and invalidate the SetNode moved by it if auto compaction is set, see SetAutoCompact.
+func (s *Set) TryErase(data interface{}) (int, error) {
+	return s.tree.TryErase(data)
+}
+
+// Split split set into left whose data are less than data and right whose data are not less than data,
+// and then s become empty. the structural work is O(log(n)) by the join algorithm of red-black tree,
+// the larger one of left and right take over the spans of s, and the smaller one is moved to a new span.
+// all the SetNode of s are invalid after Split.
+// O(log(n)+min(left.Size(), right.Size()))
+func (s *Set) Split(data interface{}) (left, right *Set) {
+	left, right = newSet(), newSet()
+	s.tree.Split(data, &left.tree, &right.tree)
+	return left, right
+}
+
+// Join move all the data of other to s, and then other become empty.
+// data of other must be all greater or all less than data of s, otherwise it panic with ErrOverlap,
+// and other must have the same type and unique flag as s.
+// the spans of other are moved to s instead of inserting data one by one, so the SetNode of s are still valid,
+// but the SetNode of other are invalid after Join.
+// O(log(n)+other.Size())
+func (s *Set) Join(other *Set) {
+	s.tree.Join(&other.tree)
+}
+
+// Union return a new set with the data in s or other,
+// for not unique set, data appear max(m, n) times if it appear m times in s and n times in other.
+// the equal data of s is preferred.
+// O(n+m)
+func (s *Set) Union(other *Set) *Set {
+	var c = newSet()
+	s.tree.setOperation(&other.tree, opUnion, &c.tree)
+	return c
+}
+
+// Intersection return a new set with the data in both s and other,
+// for not unique set, data appear min(m, n) times if it appear m times in s and n times in other.
+// O(n+m), or O(min(n,m)*log(max(n,m))) when one set is much smaller.
+func (s *Set) Intersection(other *Set) *Set {
+	var c = newSet()
+	s.tree.setOperation(&other.tree, opIntersection, &c.tree)
+	return c
+}
+
+// Difference return a new set with the data in s but not in other,
+// for not unique set, data appear max(m-n, 0) times if it appear m times in s and n times in other.
+// O(n+m), or O(n*log(m)) when s is much smaller.
+func (s *Set) Difference(other *Set) *Set {
+	var c = newSet()
+	s.tree.setOperation(&other.tree, opDifference, &c.tree)
+	return c
+}
+
+// SymmetricDifference return a new set with the data in s or other but not both,
+// for not unique set, data appear |m-n| times if it appear m times in s and n times in other.
+// O(n+m)
+func (s *Set) SymmetricDifference(other *Set) *Set {
+	var c = newSet()
+	s.tree.setOperation(&other.tree, opSymmetricDifference, &c.tree)
+	return c
+}
+
+// IsSubsetOf report whether all the data of s is in other,
+// for not unique set, data appear in other no less times than in s.
+// O(n+m), or O(n*log(m)) when s is much smaller.
+func (s *Set) IsSubsetOf(other *Set) bool {
+	return s.Size() <= other.Size() && s.tree.emptyOperation(&other.tree, opDifference)
+}
+
+// IsDisjoint report whether s and other have no data in common.
+// O(n+m), or O(min(n,m)*log(max(n,m))) when one set is much smaller.
+func (s *Set) IsDisjoint(other *Set) bool {
+	return s.tree.emptyOperation(&other.tree, opIntersection)
+}
+
+// Equal report whether s and other have the same data,
+// for not unique set, the times of every data are the same too.
+// O(n)
+func (s *Set) Equal(other *Set) bool {
+	return s.Size() == other.Size() && s.tree.emptyOperation(&other.tree, opSymmetricDifference)
+}
+
+// Descend call fn with the nodes whose data is not greater than from in descending order
+// until fn return false. fn can erase the node passed to it, and then it continue with the next smaller node.
+// O(log(n)+k), k is the number of nodes passed to fn
+func (s *Set) Descend(from interface{}, fn func(n SetNode) bool) {
+	s.tree.Descend(from, func(n _node) bool {
+		return fn(s.pack(n))
+	})
+}
+
+// DescendRange call fn with the nodes whose data is in range (lo, hi] in descending order
+// until fn return false. fn can erase the node passed to it, and then it continue with the next smaller node.
+// O(log(n)+k), k is the number of nodes passed to fn
+func (s *Set) DescendRange(hi, lo interface{}, fn func(n SetNode) bool) {
+	s.tree.DescendRange(hi, lo, func(n _node) bool {
+		return fn(s.pack(n))
+	})
+}
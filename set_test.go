@@ -1,6 +1,8 @@
 package rbtree_test
 
 import (
+	"errors"
+	"math"
 	"sort"
 	"testing"
 
@@ -261,3 +263,945 @@ func TestSet(t *testing.T) {
 		testSet(t, 1e4, false)
 	})
 }
+
+func TestSetClone(t *testing.T) {
+	for _, unique := range []bool{true, false} {
+		var s = NewSet(unique)
+		for i := 0; i < 1000; i++ {
+			s.Insert(i % 300)
+		}
+		var c = s.Clone()
+		if c.Size() != s.Size() || c.Unique() != s.Unique() {
+			t.Fatal("clone error", c.Size(), s.Size())
+		}
+		for it, it2 := s.Begin(), c.Begin(); it != s.End(); it, it2 = it.Next(), it2.Next() {
+			if it.GetData() != it2.GetData() {
+				t.Fatal("clone data error", it.GetData(), it2.GetData())
+			}
+		}
+		s.Erase(1)
+		for i := 0; i < 300; i += 2 {
+			c.Erase(i)
+		}
+		if c.Count(1) != s.Count(3) || s.Count(2) == 0 {
+			t.Fatal("clone share memory", c.Count(1), s.Count(3))
+		}
+		if _, size := c.Check(); size != c.Size() {
+			t.Fatal("size error", size, c.Size())
+		}
+	}
+}
+
+func TestSetOrderStatistic(t *testing.T) {
+	for _, unique := range []bool{true, false} {
+		var s = NewSet(unique)
+		var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+		var slice []int
+		for i := 0; i < 2000; i++ {
+			val := rand.Int() % 500
+			if i%3 == 2 {
+				if s.Erase(val) > 0 {
+					var tmp = slice[:0]
+					for _, v := range slice {
+						if v != val {
+							tmp = append(tmp, v)
+						}
+					}
+					slice = tmp
+				}
+			} else if _, ok := s.Insert(val); ok {
+				slice = append(slice, val)
+			}
+		}
+		sort.Ints(slice)
+		if _, size := s.Check(); size != s.Size() || size != len(slice) {
+			t.Fatal("size error", size, s.Size(), len(slice))
+		}
+		for i := range slice {
+			n := s.Select(i)
+			if n.GetData() != slice[i] || s.IndexOf(n) != i {
+				t.Fatal("Select or IndexOf error", i, n.GetData(), slice[i], s.IndexOf(n))
+			}
+		}
+		if s.Select(-1) != s.End() || s.Select(len(slice)) != s.End() || s.IndexOf(s.End()) != len(slice) {
+			t.Fatal("Select or IndexOf end error")
+		}
+		for lo := -1; lo <= 501; lo += 7 {
+			if s.Rank(lo) != sort.SearchInts(slice, lo) {
+				t.Fatal("Rank error", lo, s.Rank(lo), sort.SearchInts(slice, lo))
+			}
+			for hi := lo - 7; hi <= 501; hi += 13 {
+				var count int
+				for _, v := range slice {
+					if v >= lo && v < hi {
+						count++
+					}
+				}
+				if s.CountRange(lo, hi) != count {
+					t.Fatal("CountRange error", lo, hi, s.CountRange(lo, hi), count)
+				}
+			}
+		}
+	}
+}
+
+func TestSetEraseNodeKeepHandle(t *testing.T) {
+	for _, unique := range []bool{true, false} {
+		var s = NewSet(unique)
+		var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+		var nodes = make(map[rbtree.SetNode]int)
+		for i := 0; i < 3000; i++ {
+			val := rand.Int() % 800
+			if n, ok := s.Insert(val); ok {
+				nodes[n] = val
+			}
+		}
+		for len(nodes) > 0 {
+			var erase rbtree.SetNode
+			for erase = range nodes {
+				break
+			}
+			s.EraseNode(erase)
+			delete(nodes, erase)
+			if len(nodes)%97 != 0 {
+				continue
+			}
+			for n, val := range nodes {
+				if n.GetData() != val {
+					t.Fatal("node changed after EraseNode", n.GetData(), val)
+				}
+			}
+			if _, size := s.Check(); size != s.Size() || size != len(nodes) {
+				t.Fatal("size error", size, s.Size(), len(nodes))
+			}
+		}
+	}
+}
+
+func TestSetStaleNode(t *testing.T) {
+	var s = NewSet(true)
+	for i := 0; i < 10; i++ {
+		s.Insert(i)
+	}
+	var stale = s.Find(5)
+	s.EraseNode(stale)
+	s.Insert(100) // reuse the slot of erased node
+	var mustPanic = func(name string, f func()) {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, rbtree.ErrStaleNode) {
+				t.Fatal(name, "should panic with ErrStaleNode, but got", err)
+			}
+		}()
+		f()
+	}
+	mustPanic("GetData", func() { stale.GetData() })
+	mustPanic("Next", func() { stale.Next() })
+	mustPanic("Last", func() { stale.Last() })
+	mustPanic("EraseNode", func() { s.EraseNode(stale) })
+	if s.Size() != 10 || s.Count(100) != 1 || s.Find(4).Next().GetData() != 6 {
+		t.Fatal("stale node change the set")
+	}
+}
+
+func TestSetTryMethod(t *testing.T) {
+	var s = NewSet(true)
+	if _, err := s.End().TryNext(); err != rbtree.ErrNoNext {
+		t.Fatal("TryNext of empty set", err)
+	}
+	if _, err := s.Begin().TryLast(); err != rbtree.ErrNoLast {
+		t.Fatal("TryLast of empty set", err)
+	}
+	for i := 0; i < 10; i++ {
+		s.Insert(i)
+	}
+	var i int
+	for it := s.Begin(); it.HasNext(); i++ {
+		if it.GetData() != i {
+			t.Fatal("TryNext error", it.GetData(), i)
+		}
+		var err error
+		if it, err = it.TryNext(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	i = 9
+	for it := s.End(); it.HasLast(); i-- {
+		var err error
+		if it, err = it.TryLast(); err != nil || it.GetData() != i {
+			t.Fatal("TryLast error", err, i)
+		}
+	}
+	if err := s.TryEraseNode(s.End()); err != rbtree.ErrEraseEmpty {
+		t.Fatal("TryEraseNode end", err)
+	}
+	var n = s.Find(3)
+	if !n.Valid() || s.TryEraseNode(n) != nil || n.Valid() || n.HasNext() || n.HasLast() {
+		t.Fatal("TryEraseNode error")
+	}
+	if _, err := n.TryNext(); err != rbtree.ErrStaleNode {
+		t.Fatal("TryNext of erased node", err)
+	}
+	if _, err := n.TryLast(); err != rbtree.ErrStaleNode {
+		t.Fatal("TryLast of erased node", err)
+	}
+	if err := s.TryEraseNode(n); err != rbtree.ErrStaleNode {
+		t.Fatal("TryEraseNode of erased node", err)
+	}
+	if err := NewSet(true).TryEraseNode(s.Begin()); err != rbtree.ErrNotInTree {
+		t.Fatal("TryEraseNode of other set", err)
+	}
+	if (rbtree.SetNode{}).Valid() {
+		t.Fatal("zero SetNode should not be valid")
+	}
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, rbtree.ErrNoNext) {
+			t.Fatal("Next of End should panic with ErrNoNext", err)
+		}
+	}()
+	s.End().Next()
+}
+
+func TestSetBuiltinCompare(t *testing.T) {
+	var s = rbtree.NewSet(float64(0), nil)
+	for _, val := range []float64{math.Inf(1), 1, math.NaN(), -1, math.MaxFloat64, math.Inf(-1), 0} {
+		s.Insert(val)
+	}
+	var want = []float64{math.NaN(), math.Inf(-1), -1, 0, 1, math.MaxFloat64, math.Inf(1)}
+	var i int
+	for it := s.Begin(); it != s.End(); it = it.Next() {
+		if val := it.GetData().(float64); val != want[i] && !(math.IsNaN(val) && math.IsNaN(want[i])) {
+			t.Fatal("builtin compare error", val, want[i])
+		}
+		i++
+	}
+	if s.Count(math.NaN()) != 1 {
+		t.Fatal("NaN count error")
+	}
+	var ss = rbtree.NewMultiSet(int(0), nil)
+	ss.Insert(math.MinInt64)
+	ss.Insert(math.MaxInt64)
+	if ss.Begin().GetData() != math.MinInt64 {
+		t.Fatal("builtin int compare overflow")
+	}
+	defer func() {
+		if err, _ := recover().(error); err != rbtree.ErrNoCompare {
+			t.Fatal("NewSet of unordered type should panic with ErrNoCompare", err)
+		}
+	}()
+	rbtree.NewSet(struct{}{}, nil)
+}
+
+func TestSetInsertHint(t *testing.T) {
+	var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+	for _, unique := range []bool{true, false} {
+		var s = NewSet(unique)
+		var sortSlice []int
+		var hint = s.End()
+		for i := 0; i < 2000; i++ {
+			var val = rand.Int() % 500
+			switch i % 3 {
+			case 0:
+				hint = s.End()
+			case 1:
+				hint = s.Select(rand.Int() % (s.Size() + 1))
+			}
+			n, ok := s.InsertHint(hint, val)
+			var index = sort.SearchInts(sortSlice, val)
+			var exist = index < len(sortSlice) && sortSlice[index] == val
+			if n.GetData() != val || ok == (unique && exist) {
+				t.Fatal("InsertHint error", val, n.GetData(), ok, exist)
+			}
+			if ok {
+				sortSlice = append(sortSlice, 0)
+				copy(sortSlice[index+1:], sortSlice[index:])
+				sortSlice[index] = val
+			}
+			if _, size := s.Check(); size != s.Size() || size != len(sortSlice) {
+				t.Fatal("size error", size, s.Size(), len(sortSlice))
+			}
+			hint = n
+		}
+		var i int
+		for it := s.Begin(); it != s.End(); it = it.Next() {
+			if it.GetData() != sortSlice[i] {
+				t.Fatal("go through error", it.GetData(), sortSlice[i])
+			}
+			i++
+		}
+	}
+	// inserting sorted data with hint should compare O(1) times per data
+	var compareCount int
+	var compare = func(a, b interface{}) int {
+		compareCount++
+		return rbtree.CompareInt(a, b)
+	}
+	var s = rbtree.NewMultiSet(int(0), compare)
+	var hint = s.End()
+	for i := 0; i < 1000; i++ {
+		hint, _ = s.InsertHint(hint, i/2)
+		hint = hint.Next()
+	}
+	if compareCount > 2*1000 {
+		t.Fatal("InsertHint compare too many times", compareCount)
+	}
+	if _, size := s.Check(); size != 1000 {
+		t.Fatal("size error", size)
+	}
+	compareCount = 0
+	s = rbtree.NewSet(int(0), compare)
+	hint = s.End()
+	for i := 999; i >= 0; i-- {
+		hint, _ = s.InsertHint(hint, i)
+	}
+	if compareCount > 2*1000 {
+		t.Fatal("InsertHint descending compare too many times", compareCount)
+	}
+	if _, size := s.Check(); size != 1000 {
+		t.Fatal("size error", size)
+	}
+}
+
+func TestSetFromSorted(t *testing.T) {
+	for _, unique := range []bool{true, false} {
+		for _, length := range []int{0, 1, 2, 3, 7, 8, 9, 100, 1023, 1024, 1e4} {
+			var data = make([]int, length)
+			for i := range data {
+				data[i] = i
+				if !unique {
+					data[i] = i / 3
+				}
+			}
+			var s = rbtree.NewSetFromSorted(data, nil, unique)
+			if _, size := s.Check(); size != length || s.Size() != length || s.Unique() != unique {
+				t.Fatal("size error", size, s.Size(), length)
+			}
+			var i int
+			for it := s.Begin(); it != s.End(); it = it.Next() {
+				if it.GetData() != data[i] || s.Select(i) != it {
+					t.Fatal("go through error", it.GetData(), data[i])
+				}
+				i++
+			}
+			if i != length {
+				t.Fatal("length error", i, length)
+			}
+			// the set should work as usual after build
+			for i := 0; i < length; i += 2 {
+				s.Erase(data[i])
+				s.Insert(-i)
+			}
+			if _, size := s.Check(); size != s.Size() {
+				t.Fatal("size error", size, s.Size())
+			}
+		}
+	}
+	var mustPanic = func(err error, f func()) {
+		defer func() {
+			if e, _ := recover().(error); !errors.Is(e, err) {
+				t.Fatal("should panic with", err, e)
+			}
+		}()
+		f()
+	}
+	mustPanic(rbtree.ErrNotSorted, func() { rbtree.NewSetFromSorted([]int{1, 3, 2}, nil, false) })
+	mustPanic(rbtree.ErrNotSorted, func() { rbtree.NewSetFromSorted([]int{1, 2, 2}, nil, true) })
+	mustPanic(rbtree.ErrNotSlice, func() { rbtree.NewSetFromSorted(1, nil, true) })
+	mustPanic(rbtree.ErrNotSlice, func() { rbtree.NewSetFromSorted(nil, nil, true) })
+}
+
+func TestSetSplitJoin(t *testing.T) {
+	var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+	var checkSet = func(s *rbtree.Set, want []int) {
+		if _, size := s.Check(); size != len(want) || s.Size() != len(want) {
+			t.Fatal("size error", size, s.Size(), len(want))
+		}
+		var i int
+		for it := s.Begin(); it != s.End(); it = it.Next() {
+			if it.GetData() != want[i] {
+				t.Fatal("data error", it.GetData(), want[i])
+			}
+			i++
+		}
+	}
+	for _, unique := range []bool{true, false} {
+		for _, length := range []int{0, 1, 2, 5, 17, 100, 1000} {
+			var s = NewSet(unique)
+			for i := 0; i < length; i++ {
+				s.Insert(rand.Int() % (length + 1))
+				if i%3 == 0 {
+					s.Erase(rand.Int() % (length + 1))
+				}
+			}
+			var all []int
+			for it := s.Begin(); it != s.End(); it = it.Next() {
+				all = append(all, it.GetData().(int))
+			}
+			for key := -1; key <= length+1; key += length/7 + 1 {
+				var c = s.Clone()
+				var old = c.Begin()
+				left, right := c.Split(key)
+				var index = sort.SearchInts(all, key)
+				checkSet(left, all[:index])
+				checkSet(right, all[index:])
+				if !c.Empty() || c.Begin() != c.End() || (len(all) != 0 && old.Valid()) {
+					t.Fatal("split source error")
+				}
+				if c.End().GetSet() != c || left.End().GetSet() != left || right.End().GetSet() != right {
+					t.Fatal("GetSet error")
+				}
+				// the set should work as usual after split
+				left.Insert(-1)
+				left.Erase(-1)
+				right.Insert(length + 2)
+				right.Erase(length + 2)
+				var leftBegin, rightBegin = left.Begin(), right.Begin()
+				if key%2 == 0 {
+					left.Join(right)
+					checkSet(left, all)
+					if !right.Empty() || !leftBegin.Valid() || (index != len(all) && rightBegin.Valid()) {
+						t.Fatal("join error", index, len(all))
+					}
+					left.Insert(length + 2)
+				} else {
+					right.Join(left)
+					checkSet(right, all)
+					if !left.Empty() || !rightBegin.Valid() || (index != 0 && leftBegin.Valid()) {
+						t.Fatal("join error", index, len(all))
+					}
+					right.Insert(length + 2)
+				}
+			}
+		}
+	}
+	var a, b = NewSet(true), NewSet(true)
+	a.Insert(1)
+	a.Insert(3)
+	b.Insert(2)
+	defer func() {
+		if err, _ := recover().(error); err != rbtree.ErrOverlap {
+			t.Fatal("should panic with ErrOverlap", err)
+		}
+	}()
+	a.Join(b)
+}
+
+func TestSetAlgebra(t *testing.T) {
+	var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+	var newSet = func(unique bool, length, max int) (*rbtree.Set, map[int]int) {
+		var s = NewSet(unique)
+		var count = make(map[int]int)
+		for i := 0; i < length; i++ {
+			var val = rand.Int() % max
+			if _, ok := s.Insert(val); ok {
+				count[val]++
+			}
+		}
+		return s, count
+	}
+	var checkSet = func(name string, s *rbtree.Set, want map[int]int) {
+		if _, size := s.Check(); size != s.Size() {
+			t.Fatal(name, "size error", size, s.Size())
+		}
+		var total int
+		for key, n := range want {
+			if s.Count(key) != n {
+				t.Fatal(name, "count error", key, s.Count(key), n)
+			}
+			total += n
+		}
+		if total != s.Size() {
+			t.Fatal(name, "size error", total, s.Size())
+		}
+	}
+	var min = func(a, b int) int {
+		if a < b {
+			return a
+		}
+		return b
+	}
+	for _, unique := range []bool{true, false} {
+		for _, length := range [][2]int{{0, 0}, {0, 10}, {10, 10}, {50, 70}, {3, 1000}, {1000, 5}} {
+			for _, max := range []int{10, 100, 10000} {
+				a, ca := newSet(unique, length[0], max)
+				b, cb := newSet(unique, length[1], max)
+				var union, inter, diff, symm = map[int]int{}, map[int]int{}, map[int]int{}, map[int]int{}
+				var subset, disjoint = true, true
+				for key := range ca {
+					union[key] = ca[key]
+				}
+				for key := range cb {
+					if cb[key] > union[key] {
+						union[key] = cb[key]
+					}
+					if n := min(ca[key], cb[key]); n > 0 {
+						inter[key] = n
+						disjoint = false
+					}
+				}
+				for key := range union {
+					if ca[key] > cb[key] {
+						diff[key] = ca[key] - cb[key]
+						symm[key] = ca[key] - cb[key]
+						subset = false
+					} else if cb[key] > ca[key] {
+						symm[key] = cb[key] - ca[key]
+					}
+				}
+				checkSet("union", a.Union(b), union)
+				checkSet("intersection", a.Intersection(b), inter)
+				checkSet("difference", a.Difference(b), diff)
+				checkSet("symmetric difference", a.SymmetricDifference(b), symm)
+				if a.IsSubsetOf(b) != subset || a.IsDisjoint(b) != disjoint || a.Equal(b) != (len(symm) == 0) {
+					t.Fatal("predicate error", a.IsSubsetOf(b), subset, a.IsDisjoint(b), disjoint)
+				}
+				if !a.Equal(a.Clone()) || !a.IsSubsetOf(a.Union(b)) || !a.Intersection(b).IsSubsetOf(b) {
+					t.Fatal("predicate error")
+				}
+			}
+		}
+	}
+	defer func() {
+		if err, _ := recover().(error); err != rbtree.ErrUnique {
+			t.Fatal("should panic with ErrUnique", err)
+		}
+	}()
+	NewSet(true).Union(NewSet(false))
+}
+
+func TestSetReverse(t *testing.T) {
+	var s = NewSet(false)
+	if s.RBegin() != s.REnd() || s.REnd().Base() != s.End() {
+		t.Fatal("empty set reverse error")
+	}
+	var slice = []int{5, 1, 9, 3, 3, 7}
+	for _, val := range slice {
+		s.Insert(val)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(slice)))
+	var i int
+	for it := s.RBegin(); it != s.REnd(); it = it.Next() {
+		if it.GetData() != slice[i] || it.Base().GetData() != slice[i] {
+			t.Fatal("reverse go through error", it.GetData(), slice[i])
+		}
+		i++
+	}
+	if i != len(slice) || s.REnd().Last().GetData() != 1 || s.RBegin().Base() != s.End().Last() {
+		t.Fatal("reverse iterator error", i)
+	}
+	var collect = func(f func(fn func(n rbtree.SetNode) bool), limit int) (result []int) {
+		f(func(n rbtree.SetNode) bool {
+			result = append(result, n.GetData().(int))
+			return len(result) < limit
+		})
+		return result
+	}
+	var equal = func(a, b []int) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for _, c := range []struct {
+		result, want []int
+	}{
+		{collect(func(fn func(rbtree.SetNode) bool) { s.Descend(7, fn) }, 10), []int{7, 5, 3, 3, 1}},
+		{collect(func(fn func(rbtree.SetNode) bool) { s.Descend(6, fn) }, 10), []int{5, 3, 3, 1}},
+		{collect(func(fn func(rbtree.SetNode) bool) { s.Descend(0, fn) }, 10), nil},
+		{collect(func(fn func(rbtree.SetNode) bool) { s.Descend(100, fn) }, 2), []int{9, 7}},
+		{collect(func(fn func(rbtree.SetNode) bool) { s.DescendRange(9, 3, fn) }, 10), []int{9, 7, 5}},
+		{collect(func(fn func(rbtree.SetNode) bool) { s.DescendRange(8, 0, fn) }, 10), []int{7, 5, 3, 3, 1}},
+		{collect(func(fn func(rbtree.SetNode) bool) { s.DescendRange(3, 5, fn) }, 10), nil},
+	} {
+		if !equal(c.result, c.want) {
+			t.Fatal("descend error", c.result, c.want)
+		}
+	}
+	// erase the node passed to fn
+	s.DescendRange(7, 1, func(n rbtree.SetNode) bool {
+		s.EraseNode(n)
+		return true
+	})
+	if s.Size() != 2 || s.Begin().GetData() != 1 || s.RBegin().GetData() != 9 {
+		t.Fatal("erase in descend error", s.Size())
+	}
+	defer func() {
+		if err, _ := recover().(error); err != rbtree.ErrNoNext {
+			t.Fatal("should panic with ErrNoNext", err)
+		}
+	}()
+	s.REnd().Next()
+}
+
+func TestSetNearest(t *testing.T) {
+	var s = NewSet(false)
+	for _, f := range []func(interface{}) (rbtree.SetNode, bool){s.Floor, s.Ceiling, s.Lower, s.Higher} {
+		if n, ok := f(1); ok || n != s.End() {
+			t.Fatal("empty set nearest error")
+		}
+	}
+	var slice = []int{2, 4, 4, 6, 8}
+	for _, val := range slice {
+		s.Insert(val)
+	}
+	for _, f := range []func(interface{}) (rbtree.SetNode, bool){s.Floor, s.Ceiling, s.Lower, s.Higher} {
+		if n, ok := f("4"); ok || n != s.End() {
+			t.Fatal("bad key should return End")
+		}
+	}
+	for key := 0; key <= 10; key++ {
+		// count of the values less than key and not greater than key
+		var less, notGreater = sort.SearchInts(slice, key), sort.SearchInts(slice, key+1)
+		for _, c := range []struct {
+			name  string
+			f     func(interface{}) (rbtree.SetNode, bool)
+			index int
+		}{
+			{"Floor", s.Floor, notGreater - 1},
+			{"Ceiling", s.Ceiling, less},
+			{"Lower", s.Lower, less - 1},
+			{"Higher", s.Higher, notGreater},
+		} {
+			n, ok := c.f(key)
+			if c.index < 0 || c.index >= len(slice) {
+				if ok || n != s.End() {
+					t.Fatal(c.name, "should not found", key)
+				}
+				continue
+			}
+			if !ok || n.GetData() != slice[c.index] || s.IndexOf(n) != c.index {
+				t.Fatal(c.name, "error", key, ok, s.IndexOf(n), c.index)
+			}
+		}
+	}
+}
+
+func TestSetPop(t *testing.T) {
+	var s = NewSet(false)
+	if _, ok := s.Min(); ok {
+		t.Fatal("Min of empty set should not ok")
+	}
+	if _, ok := s.PopMax(); ok || s.PopMinN(3) != nil {
+		t.Fatal("pop empty set error")
+	}
+	var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+	var slice []int
+	for i := 0; i < 1000; i++ {
+		val := rand.Int() % 300
+		s.Insert(val)
+		slice = append(slice, val)
+	}
+	sort.Ints(slice)
+	for _, k := range []int{0, 1, 2, 7, 100, 333} {
+		data := s.PopMinN(k)
+		if len(data) != k {
+			t.Fatal("PopMinN length error", len(data), k)
+		}
+		for i := range data {
+			if data[i] != slice[i] {
+				t.Fatal("PopMinN error", i, data[i], slice[i])
+			}
+		}
+		slice = slice[k:]
+		if _, size := s.Check(); size != s.Size() || size != len(slice) {
+			t.Fatal("size error", size, s.Size(), len(slice))
+		}
+		if min, ok := s.Min(); !ok || min != slice[0] || s.IndexOf(s.Begin()) != 0 {
+			t.Fatal("Min error", min, slice[0])
+		}
+	}
+	for len(slice) > 2 {
+		if max, ok := s.PopMax(); !ok || max != slice[len(slice)-1] {
+			t.Fatal("PopMax error", max, slice[len(slice)-1])
+		}
+		if min, ok := s.PopMin(); !ok || min != slice[0] {
+			t.Fatal("PopMin error", min, slice[0])
+		}
+		slice = slice[1 : len(slice)-1]
+		if _, size := s.Check(); size != len(slice) {
+			t.Fatal("size error", size, len(slice))
+		}
+	}
+	if data := s.PopMinN(10); len(data) != len(slice) || !s.Empty() || s.Begin() != s.End() {
+		t.Fatal("PopMinN all error", len(data), s.Size())
+	}
+	s.Insert(1)
+	if max, ok := s.Max(); !ok || max != 1 {
+		t.Fatal("insert after PopMinN error", max)
+	}
+}
+
+func TestSetEraseRange(t *testing.T) {
+	var rand = randint.Rand{First: 23456, Add: 12345, Mod: 1000}
+	var in = func(val, lo, hi int, bound rbtree.Bound) bool {
+		switch bound {
+		case rbtree.Closed:
+			return lo <= val && val <= hi
+		case rbtree.Open:
+			return lo < val && val < hi
+		case rbtree.LeftOpen:
+			return lo < val && val <= hi
+		}
+		return lo <= val && val < hi
+	}
+	for _, unique := range []bool{true, false} {
+		for _, bound := range []rbtree.Bound{rbtree.HalfOpen, rbtree.Closed, rbtree.Open, rbtree.LeftOpen} {
+			var s = NewSet(unique)
+			var slice []int
+			for i := 0; i < 500; i++ {
+				s.Insert(rand.Int() % 200)
+			}
+			for it := s.Begin(); it != s.End(); it = it.Next() {
+				slice = append(slice, it.GetData().(int))
+			}
+			// prefix, suffix, middle, empty and reverse ranges
+			for _, r := range [][2]int{{-1, 20}, {180, 300}, {50, 60}, {70, 70}, {100, 90}, {0, 0}, {-10, 1000}} {
+				var rest = slice[:0:0]
+				for _, val := range slice {
+					if !in(val, r[0], r[1], bound) {
+						rest = append(rest, val)
+					}
+				}
+				var num = s.EraseRange(r[0], r[1], bound)
+				if num != len(slice)-len(rest) {
+					t.Fatal("EraseRange count error", bound, r, num, len(slice)-len(rest))
+				}
+				slice = rest
+				if _, size := s.Check(); size != s.Size() || size != len(slice) {
+					t.Fatal("size error", size, s.Size(), len(slice))
+				}
+				var i int
+				for it := s.Begin(); it != s.End(); it = it.Next() {
+					if it.GetData() != slice[i] {
+						t.Fatal("EraseRange error", bound, r, it.GetData(), slice[i])
+					}
+					i++
+				}
+			}
+			if !s.Empty() {
+				t.Fatal("set should be empty", s.Size())
+			}
+		}
+	}
+}
+
+func TestSetCompact(t *testing.T) {
+	var s = NewSet(false)
+	if freed := s.Compact(); freed != 0 || !s.Empty() {
+		t.Fatal("compact empty set error", freed)
+	}
+	for i := 0; i < 10000; i++ {
+		s.Insert(i % 5000)
+	}
+	s.EraseRange(100, 4900, rbtree.HalfOpen)
+	var held []rbtree.SetNode
+	var data []interface{}
+	for n := s.Begin(); n != s.End(); n = n.Next() {
+		held, data = append(held, n), append(data, n.GetData())
+	}
+	if freed := s.Compact(); freed <= 0 {
+		t.Fatal("Compact should free memory", freed)
+	}
+	if _, size := s.Check(); size != s.Size() || size != 400 {
+		t.Fatal("size error", size, s.Size())
+	}
+	if s.Begin().GetData() != 0 || s.End().Last().GetData() != 4999 || s.Count(50) != 2 {
+		t.Fatal("Compact error")
+	}
+	// only the nodes moved out of the underused spans become invalid
+	var moved int
+	for i, n := range held {
+		if !n.Valid() {
+			moved++
+		} else if n.GetData() != data[i] {
+			t.Fatal("the node not moved by Compact error", i, n.GetData(), data[i])
+		}
+	}
+	if moved == 0 || moved == len(held) {
+		t.Fatal("Compact should move the nodes of underused spans only", moved)
+	}
+	// the compacted set can grow again
+	for i := 0; i < 1000; i++ {
+		s.Insert(-i)
+	}
+	if _, size := s.Check(); size != 1400 || s.Select(0).GetData() != -999 {
+		t.Fatal("insert after Compact error", size)
+	}
+	if freed := s.Compact(); freed < 0 {
+		t.Fatal("Compact error", freed)
+	}
+
+	// auto compact
+	s = NewSet(true)
+	s.SetAutoCompact(0.25)
+	for i := 0; i < 10000; i++ {
+		s.Insert(i)
+	}
+	var memSize, _ = rbtree.MemSize(s)
+	var compacted int
+	for i := 9999; i >= 2000; i-- {
+		s.Erase(i)
+		if size, _ := rbtree.MemSize(s); size == memSize {
+			continue
+		}
+		// compacted when size is a power of 2 and less than 10000*0.25
+		if size := s.Size() + 1; size&(size-1) != 0 || size >= 2500 {
+			t.Fatal("auto compact at wrong size", s.Size())
+		}
+		compacted++
+		memSize, _ = rbtree.MemSize(s)
+	}
+	if compacted != 1 {
+		t.Fatal("should compact automatically once", compacted)
+	}
+	if _, size := s.Check(); size != 2000 {
+		t.Fatal("size error", size)
+	}
+	// it never compact automatically in the callback of Descend,
+	// so the nodes held are still valid, and it compact after Descend
+	for i := 2000; i < 10000; i++ {
+		s.Insert(i)
+	}
+	memSize, _ = rbtree.MemSize(s)
+	s.Descend(9999, func(it rbtree.SetNode) bool {
+		var data = it.GetData().(int)
+		if data >= 2000 {
+			s.Erase(data)
+		}
+		if size, _ := rbtree.MemSize(s); size != memSize {
+			t.Fatal("compact in Descend", s.Size())
+		}
+		return data > 1024
+	})
+	if s.EraseRange(1500, 2000, rbtree.HalfOpen) != 500 {
+		t.Fatal("EraseRange error", s.Size())
+	}
+	if size, _ := rbtree.MemSize(s); size == memSize {
+		t.Fatal("should compact after Descend", s.Size())
+	}
+	// EraseNode never compact
+	s.SetAutoCompact(0.99)
+	var n = s.End()
+	for it := s.Begin(); it != s.End(); {
+		var next = it.Next()
+		s.EraseNode(it)
+		it = next
+	}
+	if !s.Empty() || !n.Valid() {
+		t.Fatal("EraseNode error", s.Size())
+	}
+}
+
+func TestSetFixedCapacity(t *testing.T) {
+	var s = NewSet(true)
+	s.Reserve(100)
+	s.SetFixedCapacity(true)
+	if !s.GetFixedCapacity() {
+		t.Fatal("GetFixedCapacity error")
+	}
+	var x int
+	if n := testing.AllocsPerRun(1, func() {
+		for x = 0; x < 100; x++ {
+			s.Insert(x)
+		}
+	}); n > 0 && !rbtree.ReflectOnly() {
+		t.Fatal("insert alloc after Reserve", n)
+	}
+	// Reserve may alloc a few more nodes since the size of span is a multiple of 8
+	for ; x < 108; x++ {
+		if _, _, err := s.TryInsert(x); err == rbtree.ErrFull {
+			break
+		}
+	}
+	var capacity = s.Size()
+	if x == 108 || capacity != x {
+		t.Fatal("TryInsert should return ErrFull", x, capacity)
+	}
+	// insert an exist data doesn't need a free node
+	if n, ok, err := s.TryInsert(50); ok || err != nil || n.GetData() != 50 {
+		t.Fatal("TryInsert exist data error", ok, err)
+	}
+	func() {
+		defer func() {
+			if err, _ := recover().(error); err != rbtree.ErrFull {
+				t.Fatal("should panic with ErrFull", err)
+			}
+		}()
+		s.Insert(-1)
+	}()
+	if _, size := s.Check(); size != capacity || s.Find(-1) != s.End() {
+		t.Fatal("tree is broken after ErrFull", size)
+	}
+	s.Erase(0)
+	if _, ok := s.Insert(-1); !ok {
+		t.Fatal("insert after erase error")
+	}
+	// Compact keep the free nodes in fixed capacity mode
+	s.EraseRange(10, 60, rbtree.HalfOpen)
+	s.Compact()
+	for i := 10; i < 60; i++ {
+		if _, ok, err := s.TryInsert(i); !ok || err != nil {
+			t.Fatal("insert after Compact error", i, err)
+		}
+	}
+
+	var n = s.Begin()
+	var end = s.End()
+	s.Reset()
+	if !s.Empty() || n.Valid() || !end.Valid() || s.Begin() != s.End() {
+		t.Fatal("Reset error", s.Size())
+	}
+	if n := testing.AllocsPerRun(1, func() {
+		for x = 0; x < 100; x++ {
+			s.Insert(x)
+		}
+	}); n > 0 && !rbtree.ReflectOnly() {
+		t.Fatal("insert alloc after Reset", n)
+	}
+	if _, size := s.Check(); size != 100 {
+		t.Fatal("size error", size)
+	}
+	// the free nodes are kept in the slice preallocated by Reset
+	if n := testing.AllocsPerRun(10, func() {
+		for x = 0; x < 100; x += 3 {
+			s.Erase(x)
+		}
+		for x = 99; x >= 0; x -= 3 {
+			s.Erase(x)
+		}
+		for x = 0; x < 100; x++ {
+			s.Insert(x)
+		}
+	}); n > 0 && !rbtree.ReflectOnly() {
+		t.Fatal("erase and insert alloc in fixed capacity mode", n)
+	}
+	if _, size := s.Check(); size != 100 {
+		t.Fatal("size error after erase and insert", size)
+	}
+	// Reserve without free node
+	for x = 100; ; x++ {
+		if _, _, err := s.TryInsert(x); err == rbtree.ErrFull {
+			break
+		}
+	}
+	s.Reserve(0)
+	if n, ok, err := s.TryInsert(-1); ok || err != rbtree.ErrFull || n != s.End() {
+		t.Fatal("TryInsert should return ErrFull", ok, err)
+	}
+	s.Erase(100)
+	if _, ok := s.Insert(-1); !ok {
+		t.Fatal("insert after Reserve without free node error")
+	}
+	s.Erase(-1)
+	s.SetFixedCapacity(false)
+	for x = 100; x < 1000; x++ {
+		s.Insert(x)
+	}
+	if _, size := s.Check(); size != 1000 {
+		t.Fatal("insert after SetFixedCapacity(false) error", size)
+	}
+}
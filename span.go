@@ -0,0 +1,233 @@
+//go:build gc && !rbtree_safe
+// +build gc,!rbtree_safe
+
+package rbtree
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// this file is the storage layer built on the runtime layout in runtime.go,
+// span_safe.go is the same layer using only reflect and slices for the rbtree_safe build.
+
+// typeID is the runtime type of interface{}, it's used to check the type of key and value
+type typeID = *_type
+
+func typeOf(x interface{}) typeID {
+	return unpackIface(x)._type
+}
+
+const _NodeSize = unsafe.Sizeof(node{})
+const _NodeOffSet = unsafe.Sizeof(struct {
+	child1 node
+	child2 node
+	parent node
+	count  countType
+	gen    genType
+}{})
+const _CountOffSet = unsafe.Offsetof(struct {
+	child1 node
+	child2 node
+	parent node
+	count  countType
+}{}.count)
+const _GenOffSet = unsafe.Offsetof(struct {
+	child1 node
+	child2 node
+	parent node
+	count  countType
+	gen    genType
+}{}.gen)
+const _PointerSize = unsafe.Sizeof(unsafe.Pointer(nil))
+
+type mem struct {
+	p           unsafe.Pointer
+	size        uintptr
+	keys        reflect.Value
+	vals        reflect.Value
+	keyArrayPtr unsafe.Pointer
+	valArrayPtr unsafe.Pointer
+}
+
+func (t *tree) getChildPointer(n node, ch uintptr) *node {
+	return (*node)(add(t.spans[n.i].p, uintptr(n.j)*_NodeOffSet+ch*_PointerSize))
+}
+
+func (t *tree) getCountPointer(n node) *countType {
+	return (*countType)(add(t.spans[n.i].p, uintptr(n.j)*_NodeOffSet+_CountOffSet))
+}
+
+func (t *tree) getGen(n node) genType {
+	return *(*genType)(add(t.spans[n.i].p, uintptr(n.j)*_NodeOffSet+_GenOffSet))
+}
+
+func (t *tree) incGen(n node) {
+	*(*genType)(add(t.spans[n.i].p, uintptr(n.j)*_NodeOffSet+_GenOffSet))++
+}
+
+// getColorByte return the byte of the color bitset of span which store the color bit of n
+func (t *tree) getColorByte(n node) *uint8 {
+	offset := t.spans[n.i].size*_NodeOffSet + uintptr(n.j)/8
+	return (*uint8)(add(t.spans[n.i].p, offset))
+}
+
+// getColor return the color of n, the bit of black is 1 and red is 0
+func (t *tree) getColor(n node) colorType {
+	return *t.getColorByte(n)&(1<<(uint(n.j)&7)) != 0
+}
+
+func (t *tree) setColor(n node, color colorType) {
+	p, bit := t.getColorByte(n), uint8(1)<<(uint(n.j)&7)
+	if color == black {
+		*p |= bit
+	} else {
+		*p &^= bit
+	}
+}
+
+func arrayAt(p unsafe.Pointer, i int, eltSize uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(p) + uintptr(i)*eltSize)
+}
+
+func (t *tree) getKey(n node) interface{} {
+	if reflectOnly {
+		return t.getValueOfKey(n).Interface()
+	}
+	key := arrayAt(t.spans[n.i].keyArrayPtr, int(n.j), t.keySize)
+	if t.indirectkey {
+		key = *(*unsafe.Pointer)(key)
+	}
+	return pack2Iface(t.keyT, key)
+}
+
+func (t *tree) getVal(n node) interface{} {
+	if reflectOnly {
+		return t.getValueOfVal(n).Interface()
+	}
+	val := arrayAt(t.spans[n.i].valArrayPtr, int(n.j), t.valSize)
+	if t.indirectval {
+		val = *(*unsafe.Pointer)(val)
+	}
+	return pack2Iface(t.valT, val)
+}
+
+func (t *tree) setKey(n node, key interface{}) {
+	if reflectOnly {
+		t.getValueOfKey(n).Set(reflect.ValueOf(key))
+		return
+	}
+	if t.raw {
+		memcopy(arrayAt(t.spans[n.i].keyArrayPtr, int(n.j), t.keySize), unpackIface(key).p, t.keySize)
+		return
+	}
+	tmp := t.key
+	*(*interface{})(unsafe.Pointer(&tmp)) = key
+	t.getValueOfKey(n).Set(tmp)
+}
+
+func (t *tree) setVal(n node, val interface{}) {
+	if reflectOnly {
+		t.getValueOfVal(n).Set(reflect.ValueOf(val))
+		return
+	}
+	if t.raw {
+		memcopy(arrayAt(t.spans[n.i].valArrayPtr, int(n.j), t.valSize), unpackIface(val).p, t.valSize)
+		return
+	}
+	tmp := t.val
+	*(*interface{})(unsafe.Pointer(&tmp)) = val
+	t.getValueOfVal(n).Set(tmp)
+}
+
+// getArrayPtrOfSliceValue return the array pointer of slice s, it return nil if reflectOnly is true
+func getArrayPtrOfSliceValue(s reflect.Value) unsafe.Pointer {
+	if reflectOnly {
+		return nil
+	}
+	return (*slice)((*eface)(unsafe.Pointer(&s)).p).array
+}
+
+// rawMode report whether the keys and values of keyType and valType are stored in
+// the raw memory of span next to the node data, which is allowed for pointer-free
+// types whose size is not zero, so the span is never scanned by GC.
+// valType is nil if the tree has no value.
+func rawMode(keyType, valType reflect.Type) bool {
+	var noPointers = func(typ reflect.Type) bool {
+		return typ.Size() > 0 && isNoPtrIface(typeOf(reflect.Zero(typ).Interface()))
+	}
+	return !reflectOnly && noPointersOK && noPointers(keyType) && (valType == nil || noPointers(valType))
+}
+
+// spanMemSize return the byte size of the node data of a span with size nodes
+func spanMemSize(size uintptr) uintptr {
+	return size*_NodeOffSet + colorMemSize(size)
+}
+
+// colorMemSize return the byte size of the color bitset of a span with size nodes,
+// it's rounded up to 8 bytes so the keys stored after it in raw mode are aligned.
+func colorMemSize(size uintptr) uintptr {
+	return (size + 63) / 64 * 8
+}
+
+// addSpan append a span which can store size nodes to spans, and return the index of it.
+// size must be a multiple of 8
+func (t *tree) addSpan(size uintptr) int32 {
+	t.spans = append(t.spans, t.makeSpan(size))
+	return int32(len(t.spans)) - 1
+}
+
+// makeSpan alloc the memory of a span which can store size nodes.
+// in raw mode, the keys and values are stored after the node data in the same memory,
+// and span.keys and span.vals are the slices of them.
+func (t *tree) makeSpan(size uintptr) mem {
+	if t.raw {
+		var nodeSize = spanMemSize(size)
+		span := mem{p: newmem(nodeSize + size*(t.keySize+t.valSize)), size: size}
+		span.keyArrayPtr = add(span.p, nodeSize)
+		span.keys = reflect.NewAt(reflect.ArrayOf(int(size), t.keyType), span.keyArrayPtr).Elem().Slice(0, int(size))
+		if t.valType != nil {
+			span.valArrayPtr = add(span.keyArrayPtr, size*t.keySize)
+			span.vals = reflect.NewAt(reflect.ArrayOf(int(size), t.valType), span.valArrayPtr).Elem().Slice(0, int(size))
+		}
+		return span
+	}
+	span := mem{p: newmem(spanMemSize(size)), size: size}
+	span.keys = reflect.MakeSlice(reflect.SliceOf(t.keyType), int(size), int(size))
+	span.keyArrayPtr = getArrayPtrOfSliceValue(span.keys)
+	if t.valType != nil {
+		span.vals = reflect.MakeSlice(reflect.SliceOf(t.valType), int(size), int(size))
+		span.valArrayPtr = getArrayPtrOfSliceValue(span.vals)
+	}
+	//fmt.Println("keys:", span.keys.String(), "vals:", span.vals.String())
+	return span
+}
+
+func (t *tree) cloneSpan(src mem) mem {
+	span := t.makeSpan(src.size)
+	if t.raw {
+		memcopy(span.p, src.p, spanMemSize(src.size)+src.size*(t.keySize+t.valSize))
+		return span
+	}
+	memcopy(span.p, src.p, spanMemSize(src.size))
+	reflect.Copy(span.keys, src.keys)
+	if t.valType != nil {
+		reflect.Copy(span.vals, src.vals)
+	}
+	return span
+}
+
+// clearData set the key and value of n to zero value
+func (t *tree) clearData(n node) {
+	if t.raw {
+		memclr(arrayAt(t.spans[n.i].keyArrayPtr, int(n.j), t.keySize), t.keySize)
+		if t.valType != nil {
+			memclr(arrayAt(t.spans[n.i].valArrayPtr, int(n.j), t.valSize), t.valSize)
+		}
+		return
+	}
+	t.setValueOfKey(n, t.getValueOfKey(t.header)) // key of header is zero value of key type
+	if t.valType != nil {
+		t.setValueOfVal(n, t.getValueOfVal(t.header)) // value of header is zero value of value type
+	}
+}
@@ -0,0 +1,150 @@
+//go:build !gc || rbtree_safe
+// +build !gc rbtree_safe
+
+package rbtree
+
+import (
+	"reflect"
+)
+
+// this file is the storage layer of the rbtree_safe build, it's selected automatically
+// when the compiler is not gc. it use only reflect and slices instead of the runtime
+// layout in runtime.go, so it's slower and GetKey, GetVal and GetData return a copy.
+
+// reflectOnly report whether key and value are read and written only by reflect,
+// it's always true in the rbtree_safe build.
+const reflectOnly = true
+
+// typeID is the type of interface{}, it's used to check the type of key and value
+type typeID = reflect.Type
+
+func typeOf(x interface{}) typeID {
+	return reflect.TypeOf(x)
+}
+
+// nodeData is the node data of a node except color, color is stored in the bitset of span
+type nodeData struct {
+	// child [2]node and parent node
+	link  [3]node
+	count countType
+	gen   genType
+}
+
+var _NodeDataSize = reflect.TypeOf(nodeData{}).Size()
+
+type mem struct {
+	nodes  []nodeData
+	colors []uint8
+	size   uintptr
+	keys   reflect.Value
+	vals   reflect.Value
+}
+
+func (t *tree) getChildPointer(n node, ch uintptr) *node {
+	return &t.spans[n.i].nodes[n.j].link[ch]
+}
+
+func (t *tree) getCountPointer(n node) *countType {
+	return &t.spans[n.i].nodes[n.j].count
+}
+
+func (t *tree) getGen(n node) genType {
+	return t.spans[n.i].nodes[n.j].gen
+}
+
+func (t *tree) incGen(n node) {
+	t.spans[n.i].nodes[n.j].gen++
+}
+
+// getColor return the color of n, the bit of black is 1 and red is 0
+func (t *tree) getColor(n node) colorType {
+	return t.spans[n.i].colors[n.j/8]&(1<<(uint(n.j)&7)) != 0
+}
+
+func (t *tree) setColor(n node, color colorType) {
+	p, bit := &t.spans[n.i].colors[n.j/8], uint8(1)<<(uint(n.j)&7)
+	if color == black {
+		*p |= bit
+	} else {
+		*p &^= bit
+	}
+}
+
+func (t *tree) getKey(n node) interface{} {
+	return t.getValueOfKey(n).Interface()
+}
+
+func (t *tree) getVal(n node) interface{} {
+	return t.getValueOfVal(n).Interface()
+}
+
+func (t *tree) setKey(n node, key interface{}) {
+	t.getValueOfKey(n).Set(reflect.ValueOf(key))
+}
+
+func (t *tree) setVal(n node, val interface{}) {
+	t.getValueOfVal(n).Set(reflect.ValueOf(val))
+}
+
+// rawMode report whether the keys and values are stored in the raw memory of span,
+// it's always false in the rbtree_safe build.
+func rawMode(keyType, valType reflect.Type) bool {
+	return false
+}
+
+// spanMemSize return the byte size of the node data of a span with size nodes
+func spanMemSize(size uintptr) uintptr {
+	return size*_NodeDataSize + colorMemSize(size)
+}
+
+// colorMemSize return the byte size of the color bitset of a span with size nodes
+func colorMemSize(size uintptr) uintptr {
+	return (size + 7) / 8
+}
+
+// addSpan append a span which can store size nodes to spans, and return the index of it.
+// size must be a multiple of 8
+func (t *tree) addSpan(size uintptr) int32 {
+	span := mem{nodes: make([]nodeData, size), colors: make([]uint8, colorMemSize(size)), size: size}
+	span.keys = reflect.MakeSlice(reflect.SliceOf(t.keyType), int(size), int(size))
+	if t.valType != nil {
+		span.vals = reflect.MakeSlice(reflect.SliceOf(t.valType), int(size), int(size))
+	}
+	t.spans = append(t.spans, span)
+	return int32(len(t.spans)) - 1
+}
+
+func (t *tree) cloneSpan(src mem) mem {
+	span := mem{nodes: make([]nodeData, src.size), colors: make([]uint8, colorMemSize(src.size)), size: src.size}
+	copy(span.nodes, src.nodes)
+	copy(span.colors, src.colors)
+	span.keys = reflect.MakeSlice(src.keys.Type(), int(src.size), int(src.size))
+	reflect.Copy(span.keys, src.keys)
+	if t.valType != nil {
+		span.vals = reflect.MakeSlice(src.vals.Type(), int(src.size), int(src.size))
+		reflect.Copy(span.vals, src.vals)
+	}
+	return span
+}
+
+// clearData set the key and value of n to zero value
+func (t *tree) clearData(n node) {
+	t.setValueOfKey(n, t.getValueOfKey(t.header)) // key of header is zero value of key type
+	if t.valType != nil {
+		t.setValueOfVal(n, t.getValueOfVal(t.header)) // value of header is zero value of value type
+	}
+}
+
+// isDirectIface report whether the value of typ is stored directly in interface
+func isDirectIface(typ typeID) bool {
+	return directIfaceType(typ)
+}
+
+func noescapeInterface(x interface{}) interface{} {
+	return x
+}
+
+// NoescapeInterface return x, the arguments escape to heap in the rbtree_safe build.
+func NoescapeInterface(x interface{}) interface{} {
+	return x
+}
@@ -1 +1,5 @@
-go test -coverprofile=cover.out -v ; go tool cover -html=cover.out ; rm cover.out
\ No newline at end of file
+go test -coverprofile=cover.out -v ; go tool cover -html=cover.out ; rm cover.out
+# the storage layer without runtime internals
+go test -tags rbtree_safe ./...
+# the safe build must not import unsafe
+go list -tags rbtree_safe -f '{{join .Imports " "}} {{join .TestImports " "}} {{join .XTestImports " "}}' . ./comparator | grep -w unsafe && echo "rbtree_safe build imports unsafe"
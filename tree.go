@@ -2,9 +2,11 @@ package rbtree
 
 import (
 	"errors"
+	"math/bits"
 	"reflect"
 	"sync"
-	"unsafe"
+
+	"github.com/cdongyang/rbtree/comparator"
 )
 
 var (
@@ -16,21 +18,30 @@ var (
 	ErrNoValue    = errors.New("tree has no value")
 	ErrBadKey     = errors.New("not same key type with tree")
 	ErrBadValue   = errors.New("not same value type with tree")
+	ErrStaleNode  = errors.New("node has been erased from tree")
+	ErrNoCompare  = errors.New("no builtin compare func for key type")
+	ErrNotSlice   = errors.New("data is not a slice")
+	ErrNotSorted  = errors.New("data is not sorted or has equal keys in unique tree")
+	ErrBadLength  = errors.New("length of keys and values are not equal")
+	ErrOverlap    = errors.New("key range of trees overlap")
+	ErrUnique     = errors.New("one tree is unique but the other is not")
+	ErrFull       = errors.New("tree is full in fixed capacity mode")
 )
 
-const _NodeSize = unsafe.Sizeof(node{})
-const _NodeOffSet = unsafe.Offsetof(struct {
-	child1 node
-	child2 node
-	parent node
-	color  colorType
-}{}.color)
-const _PointerSize = unsafe.Sizeof(unsafe.Pointer(nil))
 const _DefaultMaxSpan = 1024
-const _ColorSize = unsafe.Sizeof(colorType(false))
+
+// onRotate is called by rotate if it is not nil, it's only set by tests to count rotations.
+var onRotate func()
 
 type colorType bool
 
+// countType is the type of the number of nodes in a subtree
+type countType uint32
+
+// genType is the type of generation of a node,
+// generation increase when the node is freed by deleteNode
+type genType uint32
+
 const (
 	red   = false
 	black = true
@@ -39,6 +50,12 @@ const (
 type _node struct {
 	node
 	tree *tree
+	// gen is the generation of node when _node is packed,
+	// if it's not equal to generation of node, the node has been erased
+	gen genType
+	// treeGen is the generation of tree when _node is packed,
+	// if it's not equal to generation of tree, all nodes of tree have been moved out
+	treeGen genType
 }
 
 type node struct {
@@ -47,15 +64,31 @@ type node struct {
 }
 
 func (n _node) GetKey() interface{} {
+	n.tree.checkNode(n)
 	return n.tree.getKey(n.node)
 }
 
 func (n _node) GetVal() interface{} {
+	n.tree.checkNode(n)
 	return n.tree.getVal(n.node)
 }
 
 func (n _node) SetVal(val interface{}) {
+	if err := n.TrySetVal(val); err != nil {
+		panic(err)
+	}
+}
+
+// TrySetVal is like SetVal, but it return an error instead of panic.
+func (n _node) TrySetVal(val interface{}) error {
+	if err := n.tree.validNode(n); err != nil {
+		return err
+	}
+	if err := n.tree.checkVal(val); err != nil {
+		return err
+	}
 	n.tree.setVal(n.node, val)
+	return nil
 }
 
 // O(1)
@@ -68,23 +101,57 @@ func (n _node) Last() _node {
 	return n.tree.lastNode(n)
 }
 
-type mem struct {
-	p           unsafe.Pointer
-	size        uintptr
-	keys        reflect.Value
-	vals        reflect.Value
-	keyArrayPtr unsafe.Pointer
-	valArrayPtr unsafe.Pointer
+// Valid report whether n is a node of it's tree and hasn't been erased,
+// End of tree is valid.
+func (n _node) Valid() bool {
+	return n.tree.validNode(n) == nil
+}
+
+// HasNext report whether n is valid and n is not End of tree.
+func (n _node) HasNext() bool {
+	return n.Valid() && !sameNode(n.node, n.tree.end())
+}
+
+// HasLast report whether n is valid and n is not Begin of tree.
+func (n _node) HasLast() bool {
+	return n.Valid() && !sameNode(n.node, n.tree.begin())
+}
+
+// TryNext is like Next, but it return an error instead of panic.
+// O(1)
+func (n _node) TryNext() (_node, error) {
+	if err := n.tree.validNode(n); err != nil {
+		return n, err
+	}
+	if sameNode(n.node, n.tree.end()) {
+		return n, ErrNoNext
+	}
+	return n.tree.pack(n.tree.next(n.node)), nil
+}
+
+// TryLast is like Last, but it return an error instead of panic.
+// O(1)
+func (n _node) TryLast() (_node, error) {
+	if err := n.tree.validNode(n); err != nil {
+		return n, err
+	}
+	if sameNode(n.node, n.tree.begin()) {
+		return n, ErrNoLast
+	}
+	return n.tree.pack(n.tree.last(n.node)), nil
 }
 
 type tree struct {
+	// owner is the Map, Set, MapOf or SetOf which embed the tree,
+	// it's used by GetMap and GetSet of node
+	owner       interface{}
 	header      node
 	keyType     reflect.Type
 	valType     reflect.Type
 	key         reflect.Value
 	val         reflect.Value
-	keyT        *_type
-	valT        *_type
+	keyT        typeID
+	valT        typeID
 	keySize     uintptr
 	valSize     uintptr
 	size        int
@@ -92,6 +159,12 @@ type tree struct {
 	unique      bool
 	indirectkey bool
 	indirectval bool
+	// raw means the keys and values are pointer-free and stored in the memory of span
+	// next to the node data, they are copied by memmove and never scanned by GC
+	raw bool
+	// noTypeCheck means don't check the type of key and value argument,
+	// the type of argument must be same with tree if it's true
+	noTypeCheck bool
 	// maxSpan means the max number of node alloc to a span of spans
 	// it must be a mutipile of 8
 	maxSpan uint32
@@ -100,13 +173,30 @@ type tree struct {
 	curSpan uintptr
 	// spans is the memory to store node data, key and value.
 	// it arrange in this way:
-	// maxSpan*(child [2]node,parent node),maxSpan*(color colorType).
-	// color also can store as a bit
+	// maxSpan*(child [2]node,parent node,count countType,gen genType),maxSpan bits of color.
+	// count is the number of nodes of the subtree whose root is the node,
+	// count of header is always 0.
+	// gen is the generation of the node, it's used to find out the erased _node.
+	// color is stored as a bit, black is 1 and red is 0.
 	spans []mem
-	// freeNodes store the node free by deleteNode
+	// freeNodes store the node free by deleteNode, node is popped from the end of the last slice.
 	// use two-dimension slice to avoid a too long append action in a tree action
-	// when there is no free slice to free node, alloc a slice whose len is curSpan
+	// when there is no free slice to free node, reuse the slice emptied by allocNode
+	// behind len(freeNodes), or alloc a slice whose len is curSpan.
+	// Reserve and Reset merge them to one slice whose cap is the capacity of tree.
 	freeNodes [][]node
+	// treeGen increase when all nodes of tree are moved out by Split or Join,
+	// so that the _node packed before is invalid
+	treeGen genType
+	// compactRatio is the ratio of live nodes to all the allocated nodes
+	// under which the tree is compacted automatically, 0 means never
+	compactRatio float64
+	// walking is the depth of nested walk, the tree is never compacted automatically
+	// while walking, so the nodes held by the callback are still valid
+	walking int
+	// fixed means the tree never alloc a new span to insert a node,
+	// insert panic with ErrFull when there is no free node
+	fixed bool
 	// ensure that tree only Init once
 	onceInit sync.Once
 }
@@ -118,39 +208,56 @@ func (t *tree) Init(unique bool, key, val interface{}, compare func(a, b interfa
 }
 
 func (t *tree) init(unique bool, key, val interface{}, compare func(a, b interface{}) int) {
-	t.header = node{-1, -1}
-	t.unique = unique
-	t.size = 0
-	t.compare = compare
-	t.spans = nil
-	t.freeNodes = nil
-	t.maxSpan = _DefaultMaxSpan
-
 	if key == nil {
-		panic(ErrNoData.Error())
+		panic(ErrNoData)
 	}
 	//fmt.Println(t.keyType.String(), t.valType.String())
-	t.keyType = reflect.TypeOf(key)
 	t.key = reflect.ValueOf(key)
-	t.keyT = unpackIface(key)._type
-	t.keySize = t.keyType.Size()
+	t.keyT = typeOf(key)
 	t.indirectkey = isDirectIface(t.keyT)
+	var valType reflect.Type
 	if val != nil {
-		t.valType = reflect.TypeOf(val)
+		valType = reflect.TypeOf(val)
 		t.val = reflect.ValueOf(val)
-		t.valT = unpackIface(val)._type
-		t.valSize = t.valType.Size()
+		t.valT = typeOf(val)
 		t.indirectval = isDirectIface(t.valT)
 	}
-	t.header = t.newNode(key, val)
-	t.getValueOfKey(t.header).Set(reflect.Zero(t.keyType)) // set key of header to zero value of key type
-	if t.valType != nil {
-		t.getValueOfVal(t.header).Set(reflect.Zero(t.valType)) // set value of header to zero value of value type
+	if compare == nil {
+		// use builtin compare func of key type
+		compare = comparator.Builtin(reflect.TypeOf(key))
+		if compare == nil {
+			panic(ErrNoCompare)
+		}
+	}
+	t.initType(unique, reflect.TypeOf(key), valType, compare)
+}
+
+// initType init the tree with key type and value type,
+// valType is nil if the tree has no value.
+func (t *tree) initType(unique bool, keyType, valType reflect.Type, compare func(a, b interface{}) int) {
+	t.header = node{-1, -1}
+	t.unique = unique
+	t.size = 0
+	t.spans = nil
+	t.freeNodes = nil
+	t.maxSpan = _DefaultMaxSpan
+
+	t.compare = compare
+	t.keyType = keyType
+	t.keySize = keyType.Size()
+	t.valType = valType
+	if valType != nil {
+		t.valSize = valType.Size()
 	}
+	t.raw = rawMode(keyType, valType)
+	// the key and value of a new node is zero value of it's type,
+	// so key and value of header is zero value too
+	t.header = t.allocNode()
 	t.setChild(t.header, 0, t.end())
 	t.setChild(t.header, 1, t.end())
 	t.setParent(t.header, t.end())
 	t.setColor(t.header, red)
+	t.setCount(t.header, 0)
 }
 
 func (t *tree) SetMaxSpan(maxSpan uint32) {
@@ -164,12 +271,43 @@ func (t *tree) GetMaxSpan() uint32 {
 	return t.maxSpan
 }
 
-func (t *tree) getChild(n node, ch uintptr) node {
-	return *t.getChildPointer(n, ch)
+// SetTypeCheck set whether to check the type of key and value argument, default is true.
+// if the type of argument is not same with tree, checked method will panic with
+// ErrBadKey or ErrBadValue and Try method will return them.
+// turn it off only when the type of argument is always right,
+// otherwise the memory of tree will be corrupted.
+func (t *tree) SetTypeCheck(check bool) {
+	t.noTypeCheck = !check
+}
+
+func (t *tree) GetTypeCheck() bool {
+	return !t.noTypeCheck
 }
 
-func (t *tree) getChildPointer(n node, ch uintptr) *node {
-	return (*node)(add(t.spans[n.i].p, uintptr(n.j)*_NodeOffSet+ch*_PointerSize))
+// checkKey return ErrBadKey if type of key is not the key type of tree
+func (t *tree) checkKey(key interface{}) error {
+	if !t.noTypeCheck && typeOf(key) != t.keyT {
+		return ErrBadKey
+	}
+	return nil
+}
+
+// checkVal return ErrBadValue if type of val is not the value type of tree
+func (t *tree) checkVal(val interface{}) error {
+	if !t.noTypeCheck && t.valType != nil && typeOf(val) != t.valT {
+		return ErrBadValue
+	}
+	return nil
+}
+
+func (t *tree) mustCheckKey(key interface{}) {
+	if err := t.checkKey(key); err != nil {
+		panic(err)
+	}
+}
+
+func (t *tree) getChild(n node, ch uintptr) node {
+	return *t.getChildPointer(n, ch)
 }
 
 func (t *tree) setChild(n node, ch uintptr, child node) {
@@ -188,17 +326,27 @@ func (t *tree) setParent(n node, parent node) {
 	*t.getChildPointer(n, 2) = parent
 }
 
-func (t *tree) getColor(n node) colorType {
-	return *t.getColorPointer(n)
+// getCount return the number of nodes of the subtree whose root is n,
+// it return 0 if n is end
+func (t *tree) getCount(n node) int {
+	return int(*t.getCountPointer(n))
+}
+
+func (t *tree) setCount(n node, count int) {
+	*t.getCountPointer(n) = countType(count)
 }
 
-func (t *tree) setColor(n node, color colorType) {
-	*t.getColorPointer(n) = color
+// updateCount recompute the count of n by it's children, n must not be end
+func (t *tree) updateCount(n node) {
+	t.setCount(n, t.getCount(t.getChild(n, 0))+t.getCount(t.getChild(n, 1))+1)
 }
 
-func (t *tree) getColorPointer(n node) *colorType {
-	offset := t.spans[n.i].size*(_NodeOffSet) + uintptr(n.j)*_ColorSize
-	return (*colorType)(add(t.spans[n.i].p, offset))
+// addCount add delta to the count of n and all the ancestors of n
+func (t *tree) addCount(n node, delta int) {
+	for !sameNode(n, t.end()) {
+		t.setCount(n, t.getCount(n)+delta)
+		n = t.getParent(n)
+	}
 }
 
 func (t *tree) getValueOfKey(n node) reflect.Value {
@@ -217,115 +365,312 @@ func (t *tree) setValueOfVal(n node, val reflect.Value) {
 	t.getValueOfVal(n).Set(val)
 }
 
-func arrayAt(p unsafe.Pointer, i int, eltSize uintptr) unsafe.Pointer {
-	return unsafe.Pointer(uintptr(p) + uintptr(i)*eltSize)
-}
-
-func (t *tree) getKey(n node) interface{} {
-	key := arrayAt(t.spans[n.i].keyArrayPtr, int(n.j), t.keySize)
-	if t.indirectkey {
-		key = *(*unsafe.Pointer)(key)
-	}
-	return pack2Iface(t.keyT, key)
-}
-
-func (t *tree) getVal(n node) interface{} {
-	val := arrayAt(t.spans[n.i].valArrayPtr, int(n.j), t.valSize)
-	if t.indirectval {
-		val = *(*unsafe.Pointer)(val)
-	}
-	return pack2Iface(t.valT, val)
-}
-
-func (t *tree) setKey(n node, key interface{}) {
-	tmp := t.key
-	*(*interface{})(unsafe.Pointer(&tmp)) = key
-	t.getValueOfKey(n).Set(tmp)
-}
-
-func (t *tree) setVal(n node, val interface{}) {
-	tmp := t.val
-	*(*interface{})(unsafe.Pointer(&tmp)) = val
-	t.getValueOfVal(n).Set(tmp)
-}
-
-func (t *tree) copyNodeData(des, src node) {
-	t.setValueOfKey(des, t.getValueOfKey(src))
-	if t.valType != nil {
-		t.setValueOfVal(des, t.getValueOfVal(src))
-	}
-}
-
-func getArrayPtrOfSliceValue(s reflect.Value) unsafe.Pointer {
-	return (*slice)((*eface)(unsafe.Pointer(&s)).p).array
-}
-
 func (t *tree) newSpan() {
-	t.curSpan = uintptr(t.size)
+	// the size may not be a multiple of 8 since Extract decrease it
+	t.curSpan = (uintptr(t.size) + 7) &^ 7
 	if t.curSpan > uintptr(t.maxSpan) {
 		t.curSpan = uintptr(t.maxSpan)
 	} else if t.size <= 8 {
 		t.curSpan = 8 // begin at 8 node, and then the curSpan must be the multiple of 8
 	}
+	t.freeSpan(t.addSpan(t.curSpan), 0)
+}
 
-	span := mem{p: newmem(t.curSpan * (_NodeOffSet + _ColorSize)), size: t.curSpan}
-	span.keys = reflect.MakeSlice(reflect.SliceOf(t.keyType), int(t.curSpan), int(t.curSpan))
-	span.keyArrayPtr = getArrayPtrOfSliceValue(span.keys)
-	if t.valType != nil {
-		span.vals = reflect.MakeSlice(reflect.SliceOf(t.valType), int(t.curSpan), int(t.curSpan))
-		span.valArrayPtr = getArrayPtrOfSliceValue(span.vals)
+// freeSpan push the nodes of span i from index from to freeNodes
+func (t *tree) freeSpan(i int32, from uintptr) {
+	size := t.spans[i].size
+	if from >= size {
+		return
 	}
-	//fmt.Println("keys:", span.keys.String(), "vals:", span.vals.String())
-	t.spans = append(t.spans, span)
-	nodes := make([]node, 0, t.curSpan)
-	for i := uintptr(0); i < t.curSpan; i++ {
-		nodes = append(nodes, node{int32(len(t.spans)) - 1, int32(i)})
+	nodes := make([]node, 0, size-from)
+	// in reverse order so that the nodes are popped in order
+	for j := size; j > from; j-- {
+		nodes = append(nodes, node{i, int32(j - 1)})
 	}
 	t.freeNodes = append(t.freeNodes, nodes)
 }
 
 func (t *tree) newNode(key, val interface{}) node {
-	if len(t.freeNodes) <= 0 {
-		t.newSpan()
-	}
-	n := t.freeNodes[0][0]
-	t.freeNodes[0] = t.freeNodes[0][1:]
-	if len(t.freeNodes[0]) == 0 {
-		t.freeNodes = t.freeNodes[1:]
-	}
-	t.initNode(n)
+	n := t.allocNode()
 	t.setKey(n, key)
 	if t.valType != nil {
 		t.setVal(n, val)
 	}
+	return n
+}
+
+// allocNode pop a node from freeNodes, the key and value of it is zero value.
+func (t *tree) allocNode() node {
+	if len(t.freeNodes) <= 0 {
+		// size is 0 when the header is allocated
+		if t.fixed && t.size > 0 {
+			panic(ErrFull)
+		}
+		t.newSpan()
+	}
+	n := t.popFree()
+	t.initNode(n)
 	t.size++
 	return n
 }
 
+// popFree pop a node from the end of the last slice of freeNodes, freeNodes must not be empty.
+func (t *tree) popFree() node {
+	l := len(t.freeNodes) - 1
+	nodes := t.freeNodes[l]
+	n := nodes[len(nodes)-1]
+	t.freeNodes[l] = nodes[:len(nodes)-1]
+	if len(t.freeNodes[l]) == 0 {
+		// keep the empty slice behind len(freeNodes) for freeNode to reuse
+		t.freeNodes = t.freeNodes[:l]
+	}
+	return n
+}
+
 func (t *tree) initNode(n node) {
 	t.setChild(n, 0, t.end())
 	t.setChild(n, 1, t.end())
 	t.setParent(n, t.end())
 	t.setColor(n, red)
+	t.setCount(n, 1)
 }
 
 func (t *tree) deleteNode(n node) {
-	t.setValueOfKey(n, t.getValueOfKey(t.header)) // key of header is zero value of key type
-	if t.valType != nil {
-		t.setValueOfVal(n, t.getValueOfVal(t.header)) // value of header is zero value of value type
-	}
 	t.size--
+	t.freeNode(n)
+}
+
+// freeNode clear the key and value of n and push it to freeNodes,
+// the size of tree is not changed.
+func (t *tree) freeNode(n node) {
+	t.clearData(n)
+	t.incGen(n)
 	l := len(t.freeNodes)
 	if l <= 0 || cap(t.freeNodes[l-1]) == len(t.freeNodes[l-1]) {
-		nodes := make([]node, 0, t.curSpan)
-		t.freeNodes = append(t.freeNodes, nodes)
+		if l < cap(t.freeNodes) && cap(t.freeNodes[:l+1][l]) > 0 {
+			t.freeNodes = t.freeNodes[:l+1]
+			t.freeNodes[l] = t.freeNodes[l][:0]
+		} else {
+			nodes := make([]node, 0, t.curSpan)
+			t.freeNodes = append(t.freeNodes, nodes)
+		}
 	}
 	l = len(t.freeNodes)
 	t.freeNodes[l-1] = append(t.freeNodes[l-1], n)
 }
 
+// Compact move the nodes out of the underused spans and release these spans, and return
+// the number of bytes freed, it may be negative if there are few free nodes in the other spans.
+// a span is underused if its live nodes are not more than half of it, or less than
+// the ratio set by SetAutoCompact, the span of End is never released.
+// the nodes are moved to the free nodes of the other spans, or new spans whose size is not greater than maxSpan.
+// the structure of tree is not changed, only the nodes moved become invalid.
+// the number of free nodes is kept in fixed capacity mode.
+// O(n)
+func (t *tree) Compact() int {
+	var before, free = t.memSize(), t.freeCount()
+	var live = make([]uintptr, len(t.spans))
+	for n := t.begin(); !sameNode(n, t.end()); n = t.next(n) {
+		live[n.i]++
+	}
+	var ratio = t.compactRatio
+	if ratio < 0.5 {
+		ratio = 0.5
+	}
+	var underused = make([]bool, len(t.spans))
+	var moving, dropped int
+	for i := range t.spans {
+		if int32(i) != t.header.i && t.spans[i].size > 0 && float64(live[i]) <= float64(t.spans[i].size)*ratio {
+			underused[i] = true
+			moving += int(live[i])
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		// the free nodes of underused spans are dropped, and then reserve free nodes for the moved nodes
+		var nodes []node
+		for _, s := range t.freeNodes {
+			for _, n := range s {
+				if !underused[n.i] {
+					nodes = append(nodes, n)
+				}
+			}
+		}
+		t.freeNodes = nil
+		if len(nodes) > 0 {
+			t.freeNodes = [][]node{nodes}
+		}
+		t.Reserve(moving)
+		for n := t.begin(); !sameNode(n, t.end()); {
+			var next = t.next(n)
+			if underused[n.i] {
+				t.moveNode(n, t.popFree())
+			}
+			n = next
+		}
+		for i := range underused {
+			if underused[i] {
+				t.spans[i] = mem{} // the index of span is never reused
+			}
+		}
+	}
+	if t.fixed {
+		t.Reserve(free)
+	}
+	return before - t.memSize()
+}
+
+// moveNode move the key, value and links of n to the free node m, n is not freed.
+func (t *tree) moveNode(n, m node) {
+	t.copyNode(m, t, n)
+	t.setColor(m, t.getColor(n))
+	t.setCount(m, t.getCount(n))
+	var parent = t.getParent(n)
+	t.setParent(m, parent)
+	for ch := uintptr(0); ch < 2; ch++ {
+		var c = t.getChild(n, ch)
+		t.setChild(m, ch, c)
+		if !sameNode(c, t.end()) {
+			t.setParent(c, m)
+		}
+		if sameNode(t.most(ch), n) {
+			*t.mostPoiter(ch) = m
+		}
+	}
+	if sameNode(parent, t.end()) {
+		*t.rootPoiter() = m
+	} else if sameNode(t.getChild(parent, 0), n) {
+		t.setChild(parent, 0, m)
+	} else {
+		t.setChild(parent, 1, m)
+	}
+}
+
+// Reserve alloc spans so that n more nodes can be inserted without allocating memory,
+// the size of every new span is not greater than maxSpan.
+// O(n)
+func (t *tree) Reserve(n int) {
+	for n -= t.freeCount(); n > 0; {
+		var size = (uintptr(n) + 7) &^ 7
+		if size > uintptr(t.maxSpan) {
+			size = uintptr(t.maxSpan)
+		}
+		t.freeSpan(t.addSpan(size), 0)
+		n -= int(size)
+	}
+	t.mergeFree()
+}
+
+// mergeFree merge freeNodes to one slice whose cap is the number of nodes of spans,
+// so that freeNode never alloc memory until new span is added.
+// O(n)
+func (t *tree) mergeFree() {
+	var capacity uintptr
+	for i := range t.spans {
+		capacity += t.spans[i].size
+	}
+	if len(t.freeNodes) == 1 && uintptr(cap(t.freeNodes[0])) >= capacity {
+		return
+	}
+	nodes := make([]node, 0, capacity)
+	for i := range t.freeNodes {
+		nodes = append(nodes, t.freeNodes[i]...)
+	}
+	t.freeNodes = [][]node{nodes}
+	if len(nodes) == 0 {
+		// keep it behind len(freeNodes) for freeNode to reuse
+		t.freeNodes = t.freeNodes[:0]
+	}
+}
+
+// Reset erase all the nodes of tree, but the spans are kept to reuse.
+// all the nodes packed before become invalid except End.
+// O(n)
+func (t *tree) Reset() {
+	t.mergeFree()
+	t.freeSubtree(t.root(), func(node) {})
+	t.setRoot(t.end())
+}
+
+// SetFixedCapacity set whether the tree is in fixed capacity mode, default is false.
+// a tree in fixed capacity mode never alloc a new span to insert, so Insert panic
+// with ErrFull and TryInsert return it when there is no free node, use Reserve to
+// alloc nodes before.
+func (t *tree) SetFixedCapacity(fixed bool) {
+	t.fixed = fixed
+}
+
+func (t *tree) GetFixedCapacity() bool {
+	return t.fixed
+}
+
+// freeCount return the number of free nodes
+func (t *tree) freeCount() (count int) {
+	for i := range t.freeNodes {
+		count += len(t.freeNodes[i])
+	}
+	return count
+}
+
+// SetAutoCompact set the ratio of live nodes to all the allocated nodes,
+// under which the tree is compacted automatically after Erase, TryErase, EraseRange
+// and the Pop methods. when it happen, the nodes moved out of the underused spans
+// become invalid and panic with ErrStaleNode, even if they are not erased, so don't hold nodes
+// across these methods if auto compaction is set.
+// EraseNode never compact the tree, and the tree is never compacted automatically
+// inside the callback of Descend, DescendRange or the iterators,
+// so it's safe to erase nodes while iterating.
+// ratio <= 0 means never compact automatically, which is the default.
+func (t *tree) SetAutoCompact(ratio float64) {
+	t.compactRatio = ratio
+}
+
+// autoCompact compact the tree if the ratio of live nodes is less than compactRatio,
+// it's checked only when the size is a power of 2 to amortize the cost unless bulk is true.
+func (t *tree) autoCompact(bulk bool) {
+	if t.compactRatio <= 0 || t.fixed || t.walking > 0 || !bulk && t.size&(t.size-1) != 0 {
+		return
+	}
+	var capacity int
+	for i := range t.spans {
+		capacity += int(t.spans[i].size)
+	}
+	// don't compact small tree
+	if capacity > int(t.maxSpan) && float64(t.size) < float64(capacity)*t.compactRatio {
+		t.Compact()
+	}
+}
+
+// memSize return the bytes of spans, including node data, keys and values.
+func (t *tree) memSize() int {
+	var size uintptr
+	for i := range t.spans {
+		size += spanMemSize(t.spans[i].size) + t.spans[i].size*(t.keySize+t.valSize)
+	}
+	return int(size)
+}
+
 func (t *tree) pack(n node) _node {
-	return _node{node: n, tree: t}
+	return _node{node: n, tree: t, gen: t.getGen(n), treeGen: t.treeGen}
+}
+
+// validNode return ErrNotInTree if n is not a node of t,
+// return ErrStaleNode if n has been erased
+func (t *tree) validNode(n _node) error {
+	if t == nil || t != n.tree {
+		return ErrNotInTree
+	}
+	if n.treeGen != t.treeGen || t.spans[n.node.i].size == 0 || n.gen != t.getGen(n.node) {
+		return ErrStaleNode // the span of n is released by Compact if its size is 0
+	}
+	return nil
+}
+
+// checkNode panic if n is not a node of t or n has been erased
+func (t *tree) checkNode(n _node) {
+	if err := t.validNode(n); err != nil {
+		panic(err)
+	}
 }
 
 func (t *tree) Size() int {
@@ -390,14 +735,12 @@ func sameNode(a, b node) bool {
 // Next return the next _node of n in this tree
 // if n has no next _node, it will panic
 func (t *tree) nextNode(n _node) _node {
-	if t != n.tree {
-		panic(ErrNotInTree.Error())
-	}
+	t.checkNode(n)
 	return t.pack(t.next(n.node))
 }
 func (t *tree) next(n node) node {
 	if sameNode(n, t.end()) {
-		panic(ErrNoNext.Error())
+		panic(ErrNoNext)
 	}
 	if sameNode(n, t.most(1)) {
 		return t.end()
@@ -408,58 +751,819 @@ func (t *tree) next(n node) node {
 // Last return the last _node of n in this tree
 // if n has no last _node, it will panic
 func (t *tree) lastNode(n _node) _node {
-	if t != n.tree {
-		panic(ErrNotInTree.Error())
-	}
+	t.checkNode(n)
 	return t.pack(t.last(n.node))
 }
 func (t *tree) last(n node) node {
 	if sameNode(n, t.begin()) {
-		panic(ErrNoLast.Error())
+		panic(ErrNoLast)
 	}
 	if sameNode(n, t.end()) {
 		return t.most(1)
 	}
-	return t.gothrough(0, n)
+	return t.gothrough(0, n)
+}
+
+// reverseNextNode return the next _node of n in reverse order
+func (t *tree) reverseNextNode(n _node) _node {
+	t.checkNode(n)
+	return t.pack(t.reverseNext(n.node))
+}
+
+// reverseNext return the last node of n, and the end of tree if n is begin,
+// so end is the end of reverse order too.
+func (t *tree) reverseNext(n node) node {
+	if sameNode(n, t.end()) {
+		panic(ErrNoNext)
+	}
+	if sameNode(n, t.begin()) {
+		return t.end()
+	}
+	return t.gothrough(0, n)
+}
+
+// reverseLastNode return the last _node of n in reverse order
+func (t *tree) reverseLastNode(n _node) _node {
+	t.checkNode(n)
+	return t.pack(t.reverseLast(n.node))
+}
+
+// reverseLast return the next node of n, and the begin of tree if n is end.
+func (t *tree) reverseLast(n node) node {
+	if sameNode(n, t.most(1)) {
+		panic(ErrNoLast)
+	}
+	if sameNode(n, t.end()) {
+		return t.begin()
+	}
+	return t.gothrough(1, n)
+}
+
+// walk call fn with the nodes from n to the end of tree until fn return false,
+// the order is descending if reverse is true, otherwise ascending.
+// fn can erase the node passed to it, and then walk continue with the node after it,
+// which is got before calling fn, so it panic with ErrStaleNode if fn erase that node too.
+// if the node passed to fn is not erased, walk continue with the node after it when fn return.
+func (t *tree) walk(n node, reverse bool, fn func(n node) bool) {
+	t.walking++
+	defer func() { t.walking-- }()
+	var step = t.next
+	if reverse {
+		step = t.reverseNext
+	}
+	for !sameNode(n, t.end()) {
+		var cur, next = t.pack(n), t.pack(step(n))
+		if !fn(n) {
+			return
+		}
+		if t.validNode(cur) == nil {
+			n = step(n)
+		} else {
+			t.checkNode(next)
+			n = next.node
+		}
+	}
+}
+
+// walkData is like walk, but it call fn with a copy of the key and value of node,
+// so they are not changed when fn erase the node. fn can erase any nodes,
+// if the node after current node is erased too, such as erasing all the equal keys
+// of a multi tree, walkData continue with the first node after the key of current node.
+func (t *tree) walkData(n node, reverse bool, fn func(key, val interface{}) bool) {
+	t.walking++
+	defer func() { t.walking-- }()
+	var step = t.next
+	if reverse {
+		step = t.reverseNext
+	}
+	for !sameNode(n, t.end()) {
+		var cur, next = t.pack(n), t.pack(step(n))
+		key, val := t.copyData(n)
+		if !fn(key, val) {
+			return
+		}
+		switch {
+		case t.validNode(cur) == nil:
+			n = step(n)
+		case t.validNode(next) == nil:
+			n = next.node
+		case reverse:
+			n = t.nearest(key, 0, true)
+		default:
+			n = t.nearest(key, 1, true)
+		}
+	}
+}
+
+// descend call fn with the nodes from n to the node before stop in reverse order
+// until fn return false, end as stop means to the begin of tree.
+func (t *tree) descend(n, stop node, fn func(n node) bool) {
+	t.walk(n, true, func(n node) bool {
+		return !sameNode(n, stop) && fn(n)
+	})
+}
+
+// floorNode return the last node whose key is not greater than key,
+// or end if there is no such node.
+// O(log(n))
+func (t *tree) floorNode(key interface{}) node {
+	return t.nearest(key, 0, false)
+}
+
+// Descend call fn with the nodes whose key is not greater than from in descending order
+// until fn return false.
+// O(log(n)+k), k is the number of nodes passed to fn
+func (t *tree) Descend(_from interface{}, fn func(n _node) bool) {
+	from := noescapeInterface(_from)
+	t.mustCheckKey(from)
+	t.descend(t.floorNode(from), t.end(), func(n node) bool {
+		return fn(t.pack(n))
+	})
+}
+
+// DescendRange call fn with the nodes whose key is in range (lo, hi] in descending order
+// until fn return false.
+// O(log(n)+k), k is the number of nodes passed to fn
+func (t *tree) DescendRange(_hi, _lo interface{}, fn func(n _node) bool) {
+	hi, lo := noescapeInterface(_hi), noescapeInterface(_lo)
+	t.mustCheckKey(hi)
+	t.mustCheckKey(lo)
+	if t.compare(hi, lo) <= 0 {
+		return
+	}
+	t.descend(t.floorNode(hi), t.floorNode(lo), func(n node) bool {
+		return fn(t.pack(n))
+	})
+}
+
+func (t *tree) gothrough(ch uintptr, n node) node {
+	if !sameNode(t.getChild(n, ch), t.end()) {
+		n = t.getChild(n, ch)
+		for !sameNode(t.getChild(n, ch^1), t.end()) {
+			n = t.getChild(n, ch^1)
+		}
+		return n
+	}
+	for !sameNode(t.getParent(n), t.end()) && sameNode(t.getChild(t.getParent(n), ch), n) {
+		n = t.getParent(n)
+	}
+	return t.getParent(n)
+}
+
+// cloneTo copy t to c, c must be a zero value tree.
+// node data, keys and values are copied span by span,
+// so every node of c has the same position as t.
+// O(n)
+func (t *tree) cloneTo(c *tree) {
+	c.onceInit.Do(func() {
+		c.header = t.header
+		c.keyType, c.valType = t.keyType, t.valType
+		c.key, c.val = t.key, t.val
+		c.keyT, c.valT = t.keyT, t.valT
+		c.keySize, c.valSize = t.keySize, t.valSize
+		c.size = t.size
+		c.compare = t.compare
+		c.unique = t.unique
+		c.indirectkey, c.indirectval = t.indirectkey, t.indirectval
+		c.raw = t.raw
+		c.noTypeCheck = t.noTypeCheck
+		c.maxSpan = t.maxSpan
+		c.compactRatio = t.compactRatio
+		c.fixed = t.fixed
+		c.curSpan = t.curSpan
+		c.spans = make([]mem, len(t.spans))
+		for i := range t.spans {
+			if t.spans[i].size == 0 {
+				continue // released by Compact
+			}
+			c.spans[i] = t.cloneSpan(t.spans[i])
+		}
+		c.freeNodes = make([][]node, len(t.freeNodes))
+		for i := range t.freeNodes {
+			c.freeNodes[i] = make([]node, len(t.freeNodes[i]), cap(t.freeNodes[i]))
+			copy(c.freeNodes[i], t.freeNodes[i])
+		}
+	})
+}
+
+// initLike init c as an empty tree with the same type, compare func and settings as t,
+// c must be a zero value tree.
+func (t *tree) initLike(c *tree) {
+	c.onceInit.Do(func() {
+		c.key, c.val = t.key, t.val
+		c.keyT, c.valT = t.keyT, t.valT
+		c.indirectkey, c.indirectval = t.indirectkey, t.indirectval
+		c.initType(t.unique, t.keyType, t.valType, t.compare)
+		c.noTypeCheck = t.noTypeCheck
+		c.maxSpan = t.maxSpan
+		c.compactRatio = t.compactRatio
+		c.fixed = t.fixed
+	})
+}
+
+// moveTo move all the spans of t to c, and then t become empty,
+// c must be a zero value tree.
+// O(1)
+func (t *tree) moveTo(c *tree) {
+	c.onceInit.Do(func() {
+		c.header = t.header
+		c.keyType, c.valType = t.keyType, t.valType
+		c.key, c.val = t.key, t.val
+		c.keyT, c.valT = t.keyT, t.valT
+		c.keySize, c.valSize = t.keySize, t.valSize
+		c.size = t.size
+		c.compare = t.compare
+		c.unique = t.unique
+		c.indirectkey, c.indirectval = t.indirectkey, t.indirectval
+		c.raw = t.raw
+		c.noTypeCheck = t.noTypeCheck
+		c.maxSpan = t.maxSpan
+		c.compactRatio = t.compactRatio
+		c.fixed = t.fixed
+		c.curSpan = t.curSpan
+		c.spans = t.spans
+		c.freeNodes = t.freeNodes
+	})
+	t.reinit()
+}
+
+// reinit make t an empty tree with new spans,
+// the _node packed before become invalid.
+func (t *tree) reinit() {
+	var maxSpan = t.maxSpan
+	t.initType(t.unique, t.keyType, t.valType, t.compare)
+	t.maxSpan = maxSpan
+	t.treeGen++
+}
+
+// setRoot make the detached subtree n as the tree of t,
+// the size of t is not changed.
+// O(log(n))
+func (t *tree) setRoot(n node) {
+	*t.rootPoiter() = n
+	*t.mostPoiter(0) = n
+	*t.mostPoiter(1) = n
+	if sameNode(n, t.end()) {
+		return
+	}
+	t.setParent(n, t.end())
+	t.setColor(n, black)
+	for ch := uintptr(0); ch < 2; ch++ {
+		for !sameNode(t.getChild(t.most(ch), ch), t.end()) {
+			*t.mostPoiter(ch) = t.getChild(t.most(ch), ch)
+		}
+	}
+}
+
+// blackHeight return the number of black nodes in the path from n to a leaf.
+// O(log(n))
+func (t *tree) blackHeight(n node) (h int) {
+	for ; !sameNode(n, t.end()); n = t.getChild(n, 0) {
+		if t.getColor(n) == black {
+			h++
+		}
+	}
+	return h
+}
+
+// detach detach the subtree n whose black height is h from its parent,
+// and make the root black, it return n and the new black height.
+func (t *tree) detach(n node, h int) (node, int) {
+	if !sameNode(n, t.end()) {
+		t.setParent(n, t.end())
+		if t.getColor(n) == red {
+			t.setColor(n, black)
+			h++
+		}
+	}
+	return n, h
+}
+
+// join link the detached subtree l, node k and the detached subtree r as a subtree,
+// and return the root and black height of it.
+// keys of l must not be greater than key of k, and keys of r must not be less than it.
+// the roots of l and r must be black, lh and rh are their black height.
+// O(|lh-rh|+1)
+func (t *tree) join(l node, lh int, k node, r node, rh int) (node, int) {
+	var base, other, h, oh, ch = l, r, lh, rh, uintptr(1)
+	if lh < rh {
+		base, other, h, oh, ch = r, l, rh, lh, 0
+	}
+	// go down the ch side of base to find the black node c whose black height is oh,
+	// and then replace c with red k whose children are c and other,
+	// so k has the same black height as c, and only the red rule may be broken.
+	var parent, c = t.end(), base
+	for !sameNode(c, t.end()) && !(t.getColor(c) == black && h == oh) {
+		if t.getColor(c) == black {
+			h--
+		}
+		parent, c = c, t.getChild(c, ch)
+	}
+	t.setChild(k, ch^1, c)
+	t.setChild(k, ch, other)
+	t.setParent(k, parent)
+	if !sameNode(c, t.end()) {
+		t.setParent(c, k)
+	}
+	if !sameNode(other, t.end()) {
+		t.setParent(other, k)
+	}
+	t.setColor(k, red)
+	t.setCount(k, t.getCount(c)+t.getCount(other)+1)
+	if !sameNode(parent, t.end()) {
+		t.setChild(parent, ch, k)
+		t.addCount(parent, t.getCount(other)+1)
+	}
+	if h = lh; rh > lh {
+		h = rh
+	}
+	if t.insertAdjust(k) {
+		h++
+	}
+	var root = k
+	for !sameNode(t.getParent(root), t.end()) {
+		root = t.getParent(root)
+	}
+	return root, h
+}
+
+// split split the detached subtree n whose black height is h into
+// the subtree l whose keys are less than key and the subtree r whose keys are not less than key.
+// the roots of l and r are black, lh and rh are their black height.
+// O(log(n))
+func (t *tree) split(n node, h int, key interface{}) (l node, lh int, r node, rh int) {
+	if sameNode(n, t.end()) {
+		return t.end(), 0, t.end(), 0
+	}
+	if t.getColor(n) == black {
+		h--
+	}
+	a, ah := t.detach(t.getChild(n, 0), h)
+	b, bh := t.detach(t.getChild(n, 1), h)
+	if t.compare(key, t.getKey(n)) <= 0 {
+		l, lh, r, rh = t.split(a, ah, key)
+		r, rh = t.join(r, rh, n, b, bh)
+	} else {
+		l, lh, r, rh = t.split(b, bh, key)
+		l, lh = t.join(a, ah, n, l, lh)
+	}
+	return
+}
+
+// moveSubtree move the keys and values of the detached subtree n to a new span of c,
+// and free the nodes of n in t, c must be an empty tree with the same type as t.
+// O(size of n)
+func (t *tree) moveSubtree(n node, c *tree) {
+	var size = t.getCount(n)
+	if size == 0 {
+		return
+	}
+	var i, j = c.addSpan((uintptr(size) + 7) &^ 7), int32(0)
+	t.freeSubtree(n, func(n node) {
+		c.copyNode(node{i, j}, t, n)
+		j++
+	})
+	c.buildSpan(i, size)
+}
+
+// freeSubtree call fn with the nodes of the detached subtree n in order,
+// and each node is freed after fn return.
+// O(size of n)
+func (t *tree) freeSubtree(n node, fn func(n node)) {
+	if sameNode(n, t.end()) {
+		return
+	}
+	for !sameNode(t.getChild(n, 0), t.end()) {
+		n = t.getChild(n, 0)
+	}
+	for !sameNode(n, t.end()) {
+		var next = t.gothrough(1, n)
+		fn(n)
+		t.deleteNode(n)
+		n = next
+	}
+}
+
+// splitAt is like split, but l is the first k nodes of n and r is the others.
+// O(log(n))
+func (t *tree) splitAt(n node, h int, k int) (l node, lh int, r node, rh int) {
+	if sameNode(n, t.end()) {
+		return t.end(), 0, t.end(), 0
+	}
+	if t.getColor(n) == black {
+		h--
+	}
+	a, ah := t.detach(t.getChild(n, 0), h)
+	b, bh := t.detach(t.getChild(n, 1), h)
+	if ac := t.getCount(a); k <= ac {
+		l, lh, r, rh = t.splitAt(a, ah, k)
+		r, rh = t.join(r, rh, n, b, bh)
+	} else {
+		l, lh, r, rh = t.splitAt(b, bh, k-ac-1)
+		l, lh = t.join(a, ah, n, l, lh)
+	}
+	return
+}
+
+// Split move the keys less than key to left and the others to right, t become empty.
+// the structure is split by join algorithm of red-black tree, the smaller one of left and right
+// is moved to a new span, and the other one take over the spans of t.
+// left and right must be zero value trees.
+// O(log(n)+min(size of left, size of right))
+func (t *tree) Split(_key interface{}, left, right *tree) {
+	key := noescapeInterface(_key)
+	t.mustCheckKey(key)
+	var root = t.root()
+	l, _, r, _ := t.split(root, t.blackHeight(root), key)
+	var small, large, smallTree, largeTree = l, r, left, right
+	if t.getCount(l) > t.getCount(r) {
+		small, large, smallTree, largeTree = r, l, right, left
+	}
+	t.initLike(smallTree)
+	t.moveSubtree(small, smallTree)
+	t.setRoot(large)
+	t.moveTo(largeTree)
+}
+
+// Join move all the keys of o to t, and then o become empty.
+// keys of o must be all greater or all less than keys of t,
+// otherwise it panic with ErrOverlap.
+// the spans of o are moved to t, the nodes of t are still valid.
+// O(log(n)+ the number of nodes in spans of o)
+func (t *tree) Join(o *tree) {
+	t.checkSameTree(o)
+	if o.Size() == 0 {
+		return
+	}
+	oLeft, ok := t.joinSide(o)
+	if !ok {
+		panic(ErrOverlap)
+	}
+	var tRoot, empty = t.root(), t.Size() == 0
+	var oRoot = t.absorb(o)
+	if empty {
+		t.setRoot(oRoot)
+		return
+	}
+	var l, r = tRoot, oRoot
+	if oLeft {
+		l, r = oRoot, tRoot
+	}
+	// unlink the max node of l as the middle node to join l and r
+	t.setRoot(l)
+	var k = t.most(1)
+	t.unlinkNode(k)
+	l = t.root()
+	root, _ := t.join(l, t.blackHeight(l), k, r, t.blackHeight(r))
+	t.setRoot(root)
+}
+
+// joinSide report whether keys of o are all less than keys of t,
+// ok is false if the key range of t and o overlap, o must not be empty.
+func (t *tree) joinSide(o *tree) (oLeft, ok bool) {
+	if t == o {
+		return false, false
+	}
+	if t.Size() == 0 {
+		return false, true
+	}
+	var cmp = t.compare(o.getKey(o.most(1)), t.getKey(t.most(0)))
+	if cmp < 0 || cmp == 0 && !t.unique {
+		return true, true
+	}
+	cmp = t.compare(t.getKey(t.most(1)), o.getKey(o.most(0)))
+	return false, cmp < 0 || cmp == 0 && !t.unique
+}
+
+// absorb move all the spans and free nodes of o to t, and then o become empty,
+// the node of o is relabeled to the node of t, the header of o become a free node of t.
+// it return the detached root of o in t.
+// O(the number of nodes in spans of o)
+func (t *tree) absorb(o *tree) node {
+	var offset = int32(len(t.spans))
+	var relabel = func(n node) node {
+		if sameNode(n, o.end()) {
+			return t.end()
+		}
+		return node{n.i + offset, n.j}
+	}
+	var root = relabel(o.root())
+	for i := range o.spans {
+		for j := uintptr(0); j < o.spans[i].size; j++ {
+			var n = node{int32(i), int32(j)}
+			o.setChild(n, 0, relabel(o.getChild(n, 0)))
+			o.setChild(n, 1, relabel(o.getChild(n, 1)))
+			o.setParent(n, relabel(o.getParent(n)))
+		}
+	}
+	t.spans = append(t.spans, o.spans...)
+	for _, nodes := range o.freeNodes {
+		for i := range nodes {
+			nodes[i].i += offset
+		}
+		t.freeNodes = append(t.freeNodes, nodes)
+	}
+	t.freeNodes = append(t.freeNodes, []node{{o.header.i + offset, o.header.j}})
+	t.size += o.size - 1
+	o.spans, o.freeNodes = nil, nil
+	o.reinit()
+	return root
+}
+
+// setOp is the kind of set operation
+type setOp int
+
+const (
+	opUnion setOp = iota
+	opIntersection
+	opDifference
+	opSymmetricDifference
+)
+
+// merge merge the keys of t and o by op like the set algorithm of C++ STL,
+// and call emit with the nodes of the result in order until emit return false.
+// the equal keys are counted for not unique tree, for example, a key appear
+// max(m, n) times in union if it appear m times in t and n times in o.
+// if one tree is much smaller than the other, it skip the keys of the larger one by seek.
+// O(n+m) or O(min(n,m)*log(max(n,m)/min(n,m))) when skipping
+func (t *tree) merge(o *tree, op setOp, emit func(src *tree, n node) bool) {
+	var small, large = t.Size(), o.Size()
+	if small > large {
+		small, large = large, small
+	}
+	// whether to skip the keys which are not in result by seek instead of next
+	var gallop = small*bits.Len(uint(large)) < large
+	var skipT = op == opIntersection
+	var skipO = op == opIntersection || op == opDifference
+	var a, b = t.begin(), o.begin()
+	for !sameNode(a, t.end()) && !sameNode(b, o.end()) {
+		switch cmp := t.compare(t.getKey(a), o.getKey(b)); {
+		case cmp < 0 && skipT && gallop:
+			a = t.seek(a, o.getKey(b))
+		case cmp < 0 && skipT:
+			a = t.next(a)
+		case cmp < 0:
+			if !emit(t, a) {
+				return
+			}
+			a = t.next(a)
+		case cmp > 0 && skipO && gallop:
+			b = o.seek(b, t.getKey(a))
+		case cmp > 0 && skipO:
+			b = o.next(b)
+		case cmp > 0:
+			if !emit(o, b) {
+				return
+			}
+			b = o.next(b)
+		default:
+			if (op == opUnion || op == opIntersection) && !emit(t, a) {
+				return
+			}
+			a, b = t.next(a), o.next(b)
+		}
+	}
+	if op != opIntersection {
+		for ; !sameNode(a, t.end()); a = t.next(a) {
+			if !emit(t, a) {
+				return
+			}
+		}
+	}
+	if op == opUnion || op == opSymmetricDifference {
+		for ; !sameNode(b, o.end()); b = o.next(b) {
+			if !emit(o, b) {
+				return
+			}
+		}
+	}
+}
+
+// checkSameTree panic if o has different key type, value type or unique flag with t
+func (t *tree) checkSameTree(o *tree) {
+	if t.keyType != o.keyType {
+		panic(ErrBadKey)
+	}
+	if t.valType != o.valType {
+		panic(ErrBadValue)
+	}
+	if t.unique != o.unique {
+		panic(ErrUnique)
+	}
+}
+
+// setOperation init c as the result of t op o, c must be a zero value tree.
+// the keys of result are copied to a new span and then build a balanced tree.
+func (t *tree) setOperation(o *tree, op setOp, c *tree) {
+	t.checkSameTree(o)
+	t.initLike(c)
+	var srcs []*tree
+	var nodes []node
+	t.merge(o, op, func(src *tree, n node) bool {
+		srcs = append(srcs, src)
+		nodes = append(nodes, n)
+		return true
+	})
+	if len(nodes) == 0 {
+		return
+	}
+	var i = c.addSpan((uintptr(len(nodes)) + 7) &^ 7)
+	for j, n := range nodes {
+		c.copyNode(node{i, int32(j)}, srcs[j], n)
+	}
+	c.buildSpan(i, len(nodes))
+}
+
+// emptyOperation report whether the result of t op o is empty.
+func (t *tree) emptyOperation(o *tree, op setOp) bool {
+	t.checkSameTree(o)
+	var empty = true
+	t.merge(o, op, func(*tree, node) bool {
+		empty = false
+		return false
+	})
+	return empty
+}
+
+// extract unlink n from t, and then n is owned by the returned _node,
+// n is not freed and the size of t decrease.
+// O(log(n))
+func (t *tree) extract(n node) _node {
+	t.unlinkNode(n)
+	t.size--
+	t.incGen(n)
+	return t.pack(n)
+}
+
+// insertExtracted link the node h extracted by extract from t back to t,
+// it panic with ErrNotInTree if h is extracted from another tree, since the node
+// can't be moved to the spans of t without copying.
+// it return end and false if h is empty, and return the exist node and false
+// if t is unique and the key of h has been in t.
+// O(log(n))
+func (t *tree) insertExtracted(h _node) (_node, bool) {
+	if h.tree.validNode(h) != nil {
+		return t.End(), false
+	}
+	if h.tree != t {
+		panic(ErrNotInTree)
+	}
+	parent, ch, found := t.locate(t.getKey(h.node), t.unique)
+	if found {
+		return t.pack(parent), false
+	}
+	var n = h.node
+	t.initNode(n)
+	t.incGen(n) // h is consumed
+	t.size++
+	t.link(parent, ch, n)
+	return t.pack(n), true
+}
+
+// copyNode copy the key and value of node m of src to node n of t
+func (t *tree) copyNode(n node, src *tree, m node) {
+	t.getValueOfKey(n).Set(src.getValueOfKey(m))
+	if t.valType != nil {
+		t.getValueOfVal(n).Set(src.getValueOfVal(m))
+	}
 }
 
-func (t *tree) gothrough(ch uintptr, n node) node {
-	if !sameNode(t.getChild(n, ch), t.end()) {
-		n = t.getChild(n, ch)
-		for !sameNode(t.getChild(n, ch^1), t.end()) {
-			n = t.getChild(n, ch^1)
-		}
-		return n
+// mergeFrom move every node of o whose key is not in t to t,
+// if the key range of t and o don't overlap, it's same as Join,
+// otherwise the spans of o are moved to t and every node of o is linked to t
+// without copying, and the nodes whose key has been in unique t are copied back to o.
+// O(log(n)+m) without overlap, otherwise O(m*log(n+m))
+func (t *tree) mergeFrom(o *tree) {
+	if t.keyType != o.keyType {
+		panic(ErrBadKey)
 	}
-	for !sameNode(t.getParent(n), t.end()) && sameNode(t.getChild(t.getParent(n), ch), n) {
-		n = t.getParent(n)
+	if t.valType != o.valType {
+		panic(ErrBadValue)
 	}
-	return t.getParent(n)
+	if t == o || o.Size() == 0 {
+		return
+	}
+	if _, ok := t.joinSide(o); ok && t.unique == o.unique {
+		t.Join(o)
+		return
+	}
+	// collect the nodes in order before they are relinked, they are relabeled by absorb
+	var nodes = make([]node, 0, o.Size())
+	var offset = int32(len(t.spans))
+	for n := o.begin(); !sameNode(n, o.end()); n = o.next(n) {
+		nodes = append(nodes, node{n.i + offset, n.j})
+	}
+	var fixed = o.fixed
+	t.absorb(o)
+	o.fixed = false
+	for _, n := range nodes {
+		if parent, ch, found := t.locate(t.getKey(n), t.unique); !found {
+			t.initNode(n)
+			t.link(parent, ch, n)
+			continue
+		}
+		// the nodes are in order, so the copied node is always the max of o
+		var m = o.allocNode()
+		o.copyNode(m, t, n)
+		o.link(o.most(1), 1, m)
+		t.deleteNode(n)
+	}
+	o.fixed = fixed
 }
 
-// TODO:
-/*func (t *tree) Copy() *tree {
-
-}*/
-
 // Count return the num of n key equal to key in this tree.
-// O(log(n)+count)
+// O(log(n))
 func (t *tree) Count(_key interface{}) (count int) {
 	key := noescapeInterface(_key)
+	t.mustCheckKey(key)
+	return t.count(key)
+}
+
+// TryCount is like Count, but it return ErrBadKey instead of panic.
+func (t *tree) TryCount(_key interface{}) (int, error) {
+	key := noescapeInterface(_key)
+	if err := t.checkKey(key); err != nil {
+		return 0, err
+	}
+	return t.count(key), nil
+}
+func (t *tree) count(key interface{}) int {
 	if t.unique {
 		if sameNode(t.find(key), t.end()) {
 			return 0
 		}
 		return 1
 	}
-	var beg = t.lowerBound(key)
-	for !sameNode(beg, t.end()) && t.compare(t.getKey(beg), key) == 0 {
-		beg = t.next(beg)
-		count++
+	return t.indexOf(t.upperBound(key)) - t.indexOf(t.lowerBound(key))
+}
+
+// Rank return the number of keys less than key in this tree,
+// it's also the index of LowerBound(key).
+// O(log(n))
+func (t *tree) Rank(_key interface{}) int {
+	key := noescapeInterface(_key)
+	t.mustCheckKey(key)
+	return t.indexOf(t.lowerBound(key))
+}
+
+// CountRange return the number of keys in range [lo, hi).
+// O(log(n))
+func (t *tree) CountRange(lo, hi interface{}) int {
+	count := t.Rank(hi) - t.Rank(lo)
+	if count < 0 {
+		return 0
 	}
 	return count
 }
 
+// Select return the _node whose index is i in this tree, index begin at 0,
+// if i < 0 or i >= Size(), it return End().
+// O(log(n))
+func (t *tree) Select(i int) _node {
+	return t.pack(t.selectNode(i))
+}
+func (t *tree) selectNode(i int) node {
+	if i < 0 || i >= t.getCount(t.root()) {
+		return t.end()
+	}
+	var root = t.root()
+	for {
+		var left = t.getCount(t.getChild(root, 0))
+		switch {
+		case i < left:
+			root = t.getChild(root, 0)
+		case i > left:
+			i -= left + 1
+			root = t.getChild(root, 1)
+		default:
+			return root
+		}
+	}
+}
+
+// IndexOf return the index of n in this tree, index begin at 0,
+// index of End() is Size().
+// if n is not in tree, it will panic.
+// O(log(n))
+func (t *tree) IndexOf(n _node) int {
+	t.checkNode(n)
+	return t.indexOf(n.node)
+}
+func (t *tree) indexOf(n node) int {
+	if sameNode(n, t.end()) {
+		return t.getCount(t.root())
+	}
+	var index = t.getCount(t.getChild(n, 0))
+	for !sameNode(t.getParent(n), t.end()) {
+		var parent = t.getParent(n)
+		if sameNode(t.getChild(parent, 1), n) {
+			index += t.getCount(t.getChild(parent, 0)) + 1
+		}
+		n = parent
+	}
+	return index
+}
+
 // EqualRange return the _node range of equal key n in this tree.
 // O(2*log(n))
 func (t *tree) EqualRange(_key interface{}) (beg, end _node) {
@@ -473,8 +1577,18 @@ func (t *tree) EqualRange(_key interface{}) (beg, end _node) {
 // O(log(n))
 func (t *tree) Find(_key interface{}) _node {
 	key := noescapeInterface(_key)
+	t.mustCheckKey(key)
 	return t.pack(t.find(key))
 }
+
+// TryFind is like Find, but it return ErrBadKey instead of panic.
+func (t *tree) TryFind(_key interface{}) (_node, error) {
+	key := noescapeInterface(_key)
+	if err := t.checkKey(key); err != nil {
+		return t.End(), err
+	}
+	return t.pack(t.find(key)), nil
+}
 func (t *tree) find(key interface{}) node {
 	var root = t.root()
 	for {
@@ -497,60 +1611,273 @@ func (t *tree) find(key interface{}) node {
 // otherwise, it return the exist _node and false.
 // O(log(n))
 func (t *tree) Insert(key, val interface{}) (_node, bool) {
+	n, ok, err := t.TryInsert(key, val)
+	if err != nil {
+		panic(err)
+	}
+	return n, ok
+}
+
+// TryInsert is like Insert, but it return ErrBadKey, ErrBadValue or ErrFull instead of panic.
+func (t *tree) TryInsert(key, val interface{}) (_node, bool, error) {
+	if err := t.checkKey(key); err != nil {
+		return t.End(), false, err
+	}
+	if err := t.checkVal(val); err != nil {
+		return t.End(), false, err
+	}
+	if t.fixed && len(t.freeNodes) == 0 {
+		if _, _, found := t.locate(key, t.unique); !found {
+			return t.End(), false, ErrFull
+		}
+	}
 	n, ok := t.insert(key, val)
-	return t.pack(n), ok
+	return t.pack(n), ok, nil
 }
 func (t *tree) insert(key, val interface{}) (node, bool) {
+	parent, ch, found := t.locate(key, t.unique)
+	if found {
+		return parent, false
+	}
+	var n = t.newNode(key, val)
+	t.link(parent, ch, n)
+	return n, true
+}
+
+// InsertHint is like Insert, but it insert key as close as possible to the position just before hint.
+// if key belongs just before or after hint, it doesn't descend from root,
+// so it compare O(1) times, but the count of the ancestors is still updated up to root.
+// O(log(n))
+func (t *tree) InsertHint(hint _node, key, val interface{}) (_node, bool) {
+	t.checkNode(hint)
+	if err := t.checkKey(key); err != nil {
+		panic(err)
+	}
+	if err := t.checkVal(val); err != nil {
+		panic(err)
+	}
+	n, ok := t.insertHint(hint.node, key, val)
+	return t.pack(n), ok
+}
+func (t *tree) insertHint(hint node, key, val interface{}) (node, bool) {
+	var before = t.end()
+	if !sameNode(hint, t.begin()) {
+		before = t.last(hint)
+	}
+	parent, ch, found, ok := t.locateBetween(before, hint, key)
+	if !ok && !sameNode(hint, t.end()) {
+		parent, ch, found, ok = t.locateBetween(hint, t.next(hint), key)
+	}
+	if !ok {
+		return t.insert(key, val)
+	}
+	if found {
+		return parent, false
+	}
+	var n = t.newNode(key, val)
+	t.link(parent, ch, n)
+	return n, true
+}
+
+// locateBetween is like locate, but key must belong between the adjacent nodes before and after,
+// end as before means the begin of tree and end as after means the end of tree.
+// it return ok as false if key doesn't belong there.
+func (t *tree) locateBetween(before, after node, key interface{}) (parent node, ch uintptr, found, ok bool) {
+	if !sameNode(before, t.end()) {
+		switch cmp := t.compare(t.getKey(before), key); {
+		case cmp == 0 && t.unique:
+			return before, 0, true, true
+		case cmp > 0:
+			return t.end(), 0, false, false
+		}
+	}
+	if !sameNode(after, t.end()) {
+		switch cmp := t.compare(key, t.getKey(after)); {
+		case cmp == 0 && t.unique:
+			return after, 0, true, true
+		case cmp > 0:
+			return t.end(), 0, false, false
+		}
+	}
+	// one of before's right child and after's left child must be empty
+	switch {
+	case sameNode(before, t.end()):
+		if sameNode(after, t.end()) {
+			return t.end(), 0, false, true
+		}
+		return after, 0, false, true
+	case sameNode(t.getChild(before, 1), t.end()):
+		return before, 1, false, true
+	default:
+		return after, 0, false, true
+	}
+}
+
+// buildSorted build a balanced tree from the sorted keys and vals in a new span, t must be empty.
+// vals is a zero Value if tree has no value.
+// it panic with ErrNotSorted if keys is not sorted or has equal keys in unique tree.
+// O(n)
+func (t *tree) buildSorted(keys, vals reflect.Value) {
+	var size = keys.Len()
+	if size == 0 {
+		return
+	}
+	var i = t.addSpan((uintptr(size) + 7) &^ 7)
+	reflect.Copy(t.spans[i].keys, keys)
+	if t.valType != nil {
+		reflect.Copy(t.spans[i].vals, vals)
+	}
+	for j := 1; j < size; j++ {
+		cmp := t.compare(t.getKey(node{i, int32(j - 1)}), t.getKey(node{i, int32(j)}))
+		if cmp > 0 || cmp == 0 && t.unique {
+			panic(ErrNotSorted)
+		}
+	}
+	t.buildSpan(i, size)
+}
+
+// buildSpan link the nodes [0, size) of span i as a balanced tree of t, t must be empty,
+// the nodes after size are freed.
+func (t *tree) buildSpan(i int32, size int) {
+	// the depth of every empty child is bottom or bottom+1 after build,
+	// so the nodes at depth bottom are red and the others are black
+	*t.rootPoiter() = t.build(i, 0, size, 0, bits.Len(uint(size))-1, t.end())
+	*t.mostPoiter(0) = node{i, 0}
+	*t.mostPoiter(1) = node{i, int32(size - 1)}
+	t.size += size
+	t.freeSpan(i, uintptr(size))
+}
+
+// build link the nodes [lo, hi) of span i as a balanced subtree of parent and return the root of it
+func (t *tree) build(i int32, lo, hi, depth, bottom int, parent node) node {
+	if lo >= hi {
+		return t.end()
+	}
+	var mid = int(uint(lo+hi) >> 1)
+	var n = node{i, int32(mid)}
+	t.setParent(n, parent)
+	t.setChild(n, 0, t.build(i, lo, mid, depth+1, bottom, n))
+	t.setChild(n, 1, t.build(i, mid+1, hi, depth+1, bottom, n))
+	t.setCount(n, hi-lo)
+	if depth == bottom && depth > 0 {
+		t.setColor(n, red)
+	} else {
+		t.setColor(n, black)
+	}
+	return n
+}
+
+// locate descend the tree once to find the position to link key.
+// if stop is true and there is a node equal to key, it return the node and found is true,
+// otherwise it return the parent and the child index that key should be linked.
+func (t *tree) locate(key interface{}, stop bool) (parent node, ch uintptr, found bool) {
 	var root = t.root()
-	var rootPoiter = t.rootPoiter()
-	if sameNode(root, t.end()) {
-		*rootPoiter = t.newNode(key, val)
-		t.insertAdjust(*rootPoiter)
-		*t.mostPoiter(0) = *rootPoiter
-		*t.mostPoiter(1) = *rootPoiter
-		return *rootPoiter, true
-	}
-	var parent = t.getParent(root)
+	parent = t.end()
 	for !sameNode(root, t.end()) {
 		parent = root
 		switch cmp := t.compare(key, t.getKey(root)); {
 		case cmp == 0:
-			if t.unique {
-				return root, false
+			if stop {
+				return root, 0, true
 			}
 			fallthrough
 		case cmp < 0:
-			rootPoiter = t.getChildPointer(root, 0)
-			root = *rootPoiter
+			ch = 0
 		case cmp > 0:
-			rootPoiter = t.getChildPointer(root, 1)
-			root = *rootPoiter
+			ch = 1
 		}
+		root = t.getChild(root, ch)
 	}
-	*rootPoiter = t.newNode(key, val)
-	t.setParent((*rootPoiter), parent)
-	for ch := uintptr(0); ch < 2; ch++ {
-		if sameNode(parent, t.most(ch)) && sameNode(t.getChild(parent, ch), *rootPoiter) {
-			*t.mostPoiter(ch) = *rootPoiter
+	return parent, ch, false
+}
+
+// insertOrAssign set the value of key to val if key exist, otherwise insert key and val.
+// it return the node of key and whether it's a new node.
+func (t *tree) insertOrAssign(key, val interface{}) (node, bool) {
+	parent, ch, found := t.locate(key, true)
+	if found {
+		t.setVal(parent, val)
+		return parent, false
+	}
+	var n = t.newNode(key, val)
+	t.link(parent, ch, n)
+	return n, true
+}
+
+// loadOrStore return the node of key if key exist, otherwise insert key and val.
+// it return the node of key and whether key exist.
+func (t *tree) loadOrStore(key, val interface{}) (node, bool) {
+	parent, ch, found := t.locate(key, true)
+	if found {
+		return parent, true
+	}
+	var n = t.newNode(key, val)
+	t.link(parent, ch, n)
+	return n, false
+}
+
+// update call fn with the old value of key and whether key exist,
+// and then store the new value if keep is true, or erase key if keep is false.
+// it return the node of key, or end if key is not kept.
+func (t *tree) update(key interface{}, fn func(old interface{}, exists bool) (new interface{}, keep bool)) node {
+	parent, ch, found := t.locate(key, true)
+	var old interface{}
+	if found {
+		old = t.getVal(parent)
+	}
+	val, keep := fn(old, found)
+	if keep {
+		if err := t.checkVal(val); err != nil {
+			panic(err)
 		}
 	}
-	t.insertAdjust(*rootPoiter)
-	return *rootPoiter, true
+	switch {
+	case found && keep:
+		t.setVal(parent, val)
+		return parent
+	case found:
+		t.eraseNode(parent)
+	case keep:
+		var n = t.newNode(key, val)
+		t.link(parent, ch, n)
+		return n
+	}
+	return t.end()
+}
+
+// link n as the ch child of parent, parent's ch child must be empty.
+// it adjust leftmost and rightmost, and then rebalance the tree.
+func (t *tree) link(parent node, ch uintptr, n node) {
+	t.setParent(n, parent)
+	t.addCount(parent, 1)
+	if sameNode(parent, t.end()) {
+		*t.rootPoiter() = n
+		*t.mostPoiter(0) = n
+		*t.mostPoiter(1) = n
+		t.insertAdjust(n)
+		return
+	}
+	t.setChild(parent, ch, n)
+	if sameNode(parent, t.most(ch)) {
+		*t.mostPoiter(ch) = n
+	}
+	t.insertAdjust(n)
 }
 
 //insert n is default red
-func (t *tree) insertAdjust(n node) {
+//it return true if the black height of tree grows
+func (t *tree) insertAdjust(n node) (grow bool) {
 	var parent = t.getParent(n)
 	if sameNode(parent, t.end()) {
 		//fmt.Println("case 1: insert")
 		//n is root,set black
 		t.setColor(n, black)
-		return
+		return true
 	}
 	if t.getColor(parent) == black {
 		//fmt.Println("case 2: insert")
 		//if parent is black,do nothing
-		return
+		return false
 	}
 
 	//parent is red,grandpa can't be empty and color is black
@@ -567,8 +1894,7 @@ func (t *tree) insertAdjust(n node) {
 		t.setColor(parent, black)
 		t.setColor(grandpa, red)
 		t.setColor(uncle, black)
-		t.insertAdjust(grandpa)
-		return
+		return t.insertAdjust(grandpa)
 	}
 
 	var childCh uintptr = 0
@@ -587,11 +1913,195 @@ func (t *tree) insertAdjust(n node) {
 	t.setColor(parent, black)
 	t.setColor(grandpa, red)
 	t.rotate(parentCh^1, parent)
+	return false
+}
+
+// Min return the key and value of the first node in this tree, ok is false if the tree is empty.
+// O(1)
+func (t *tree) Min() (key, val interface{}, ok bool) {
+	return t.mostData(0)
+}
+
+// Max return the key and value of the last node in this tree, ok is false if the tree is empty.
+// O(1)
+func (t *tree) Max() (key, val interface{}, ok bool) {
+	return t.mostData(1)
+}
+
+func (t *tree) mostData(ch uintptr) (key, val interface{}, ok bool) {
+	var n = t.most(ch)
+	if sameNode(n, t.end()) {
+		return nil, nil, false
+	}
+	if t.valType != nil {
+		val = t.getVal(n)
+	}
+	return t.getKey(n), val, true
+}
+
+// PopMin erase the first node in this tree and return a copy of its key and value,
+// ok is false if the tree is empty.
+// O(log(n))
+func (t *tree) PopMin() (key, val interface{}, ok bool) {
+	return t.pop(0)
+}
+
+// PopMax erase the last node in this tree and return a copy of its key and value,
+// ok is false if the tree is empty.
+// O(log(n))
+func (t *tree) PopMax() (key, val interface{}, ok bool) {
+	return t.pop(1)
+}
+
+func (t *tree) pop(ch uintptr) (key, val interface{}, ok bool) {
+	var n = t.most(ch)
+	if sameNode(n, t.end()) {
+		return nil, nil, false
+	}
+	key, val = t.copyData(n)
+	t.eraseNode(n)
+	t.autoCompact(false)
+	return key, val, true
+}
+
+// copyData return a copy of the key and value of n,
+// which is still valid after n is erased.
+func (t *tree) copyData(n node) (key, val interface{}) {
+	if t.valType != nil {
+		val = t.getValueOfVal(n).Interface()
+	}
+	return t.getValueOfKey(n).Interface(), val
+}
+
+// PopMinN erase the first k nodes in this tree and return a copy of their keys and values,
+// it erase all the nodes if k is greater than the size of tree.
+// the prefix is cut off by split algorithm of red-black tree,
+// so the tree is rebalanced only once.
+// O(log(n)+k)
+func (t *tree) PopMinN(k int) (keys, vals []interface{}) {
+	if k > t.Size() {
+		k = t.Size()
+	}
+	if k <= 0 {
+		return nil, nil
+	}
+	keys = make([]interface{}, 0, k)
+	if t.valType != nil {
+		vals = make([]interface{}, 0, k)
+	}
+	t.eraseRank(0, k, func(n node) {
+		key, val := t.copyData(n)
+		keys = append(keys, key)
+		if vals != nil {
+			vals = append(vals, val)
+		}
+	})
+	t.autoCompact(true)
+	return keys, vals
+}
+
+// Bound is the kind of bounds of a key range
+type Bound uint8
+
+const (
+	// HalfOpen is the range [lo, hi)
+	HalfOpen Bound = iota
+	// Closed is the range [lo, hi]
+	Closed
+	// Open is the range (lo, hi)
+	Open
+	// LeftOpen is the range (lo, hi]
+	LeftOpen
+)
+
+// EraseRange erase the nodes whose keys are in the range of lo and hi
+// with the bounds kind bound, and return the number of erased nodes.
+// if the range is a prefix or suffix of the tree, it is cut off by split algorithm
+// of red-black tree and the tree is rebalanced only once.
+// O(log(n)+k), k is the number of erased nodes
+func (t *tree) EraseRange(_lo, _hi interface{}, bound Bound) int {
+	lo, hi := noescapeInterface(_lo), noescapeInterface(_hi)
+	t.mustCheckKey(lo)
+	t.mustCheckKey(hi)
+	var beg = t.rank(lo, bound == Open || bound == LeftOpen)
+	var end = t.rank(hi, bound == Closed || bound == LeftOpen)
+	if end <= beg {
+		return 0
+	}
+	t.eraseRank(beg, end-beg, nil)
+	t.autoCompact(true)
+	return end - beg
+}
+
+// rank return the number of nodes whose keys are less than key,
+// or not greater than key if equal is true.
+// O(log(n))
+func (t *tree) rank(key interface{}, equal bool) (r int) {
+	for n := t.root(); !sameNode(n, t.end()); {
+		if cmp := t.compare(t.getKey(n), key); cmp < 0 || cmp == 0 && equal {
+			r += t.getCount(t.getChild(n, 0)) + 1
+			n = t.getChild(n, 1)
+		} else {
+			n = t.getChild(n, 0)
+		}
+	}
+	return r
+}
+
+// eraseRank erase k nodes from the i-th node, fn is called with the erased nodes
+// in order before they are freed if it is not nil.
+// the range is cut off by splitAt, and an interior range is rejoined by join
+// with the min node of the right part, so the tree is rebalanced only O(log(n)) times.
+// O(log(n)+k)
+func (t *tree) eraseRank(i, k int, fn func(n node)) {
+	if fn == nil {
+		fn = func(node) {}
+	}
+	var root, size = t.root(), t.Size()
+	switch {
+	case k <= 0:
+	case i == 0:
+		l, _, r, _ := t.splitAt(root, t.blackHeight(root), k)
+		t.freeSubtree(l, fn)
+		t.setRoot(r)
+	case i+k == size:
+		l, _, r, _ := t.splitAt(root, t.blackHeight(root), i)
+		t.freeSubtree(r, fn)
+		t.setRoot(l)
+	default:
+		l, lh, r, rh := t.splitAt(root, t.blackHeight(root), i)
+		m, _, r, _ := t.splitAt(r, rh, k)
+		t.freeSubtree(m, fn)
+		// unlink the min node of r as the middle node to join l and r
+		t.setRoot(r)
+		var mid = t.most(0)
+		t.unlinkNode(mid)
+		r = t.root()
+		root, _ = t.join(l, lh, mid, r, t.blackHeight(r))
+		t.setRoot(root)
+	}
 }
 
 // Erase erase all the n keys equal to key in this tree and return the number of erase n
 func (t *tree) Erase(_key interface{}) (count int) {
 	key := noescapeInterface(_key)
+	t.mustCheckKey(key)
+	count = t.erase(key)
+	t.autoCompact(false)
+	return count
+}
+
+// TryErase is like Erase, but it return ErrBadKey instead of panic.
+func (t *tree) TryErase(_key interface{}) (int, error) {
+	key := noescapeInterface(_key)
+	if err := t.checkKey(key); err != nil {
+		return 0, err
+	}
+	var count = t.erase(key)
+	t.autoCompact(false)
+	return count, nil
+}
+func (t *tree) erase(key interface{}) (count int) {
 	if t.unique {
 		var iter = t.find(key)
 		if sameNode(iter, t.end()) {
@@ -611,23 +2121,41 @@ func (t *tree) Erase(_key interface{}) (count int) {
 }
 
 // EraseNode erase n from the tree.
-// if n is not in tree, it will panic.
-// O(1)
+// the other nodes of tree are still valid after erase.
+// if n is not in tree or n has been erased, it will panic.
+// O(log(n)), it rebalance the tree and update the count of the ancestors of n up to root.
 func (t *tree) EraseNode(n _node) {
-	if t != n.tree {
-		panic(ErrNotInTree.Error())
+	t.checkNode(n)
+	t.eraseNode(n.node)
+}
+
+// TryEraseNode is like EraseNode, but it return an error instead of panic.
+// O(log(n))
+func (t *tree) TryEraseNode(n _node) error {
+	if err := t.validNode(n); err != nil {
+		return err
+	}
+	if sameNode(n.node, t.end()) {
+		return ErrEraseEmpty
 	}
 	t.eraseNode(n.node)
+	return nil
 }
 func (t *tree) eraseNode(n node) {
+	t.unlinkNode(n)
+	t.deleteNode(n)
+}
+
+// unlinkNode remove n from the tree and rebalance it, but n is not freed
+func (t *tree) unlinkNode(n node) {
 	if sameNode(n, t.end()) {
-		panic(ErrEraseEmpty.Error())
+		panic(ErrEraseEmpty)
 	}
 	if !sameNode(t.getChild(n, 0), t.end()) && !sameNode(t.getChild(n, 1), t.end()) {
-		//if n has two child,it's last n must has no more than one child,copy to n and erase last n
-		var tmp = t.last(n)
-		t.copyNodeData(n, tmp)
-		n = tmp
+		//if n has two child,it's last n must has no more than one child,
+		//swap the position of n and last n, so that n has no more than one child,
+		//we don't copy the data of last n to n, because the node of last n may be held by others
+		t.swapNode(n, t.last(n))
 	}
 	//adjust leftmost and rightmost
 	for ch := uintptr(0); ch < 2; ch++ {
@@ -649,19 +2177,61 @@ func (t *tree) eraseNode(n node) {
 	if !sameNode(child, t.end()) {
 		t.setParent(child, parent)
 	}
-	if sameNode(parent, t.end()) {
-		*t.rootPoiter() = child
-	} else if sameNode(t.getChild(parent, 0), n) {
-		t.setChild(parent, 0, child)
-	} else {
-		t.setChild(parent, 1, child)
-	}
+	t.replaceChild(parent, n, child)
+	t.addCount(parent, -1)
 	if t.getColor(n) == black { //if n is red,just erase,otherwise adjust
 		t.eraseAdjust(child, parent)
 		//fmt.Println("eraseAdjust:")
 	}
-	t.deleteNode(n)
-	return
+}
+
+// replaceChild replace the child old of parent with n,
+// if parent is end, old is root.
+func (t *tree) replaceChild(parent, old, n node) {
+	if sameNode(parent, t.end()) {
+		*t.rootPoiter() = n
+	} else if sameNode(t.getChild(parent, 0), old) {
+		t.setChild(parent, 0, n)
+	} else {
+		t.setChild(parent, 1, n)
+	}
+}
+
+// swapNode swap the position of n and it's last node in tree,
+// n must has two children, so that last has no right child and it's in left subtree of n.
+// color and count are belong to position, so they are swapped too.
+func (t *tree) swapNode(n, last node) {
+	var (
+		parent     = t.getParent(n)
+		left       = t.getChild(n, 0)
+		right      = t.getChild(n, 1)
+		lastParent = t.getParent(last)
+		lastLeft   = t.getChild(last, 0)
+		color      = t.getColor(n)
+		count      = t.getCount(n)
+	)
+	t.replaceChild(parent, n, last)
+	t.setParent(last, parent)
+	t.setChild(last, 1, right)
+	t.setParent(right, last)
+	if sameNode(lastParent, n) {
+		t.setChild(last, 0, n)
+		t.setParent(n, last)
+	} else {
+		t.setChild(last, 0, left)
+		t.setParent(left, last)
+		t.setChild(lastParent, 1, n)
+		t.setParent(n, lastParent)
+	}
+	t.setChild(n, 0, lastLeft)
+	t.setChild(n, 1, t.end())
+	if !sameNode(lastLeft, t.end()) {
+		t.setParent(lastLeft, n)
+	}
+	t.setColor(n, t.getColor(last))
+	t.setColor(last, color)
+	t.setCount(n, t.getCount(last))
+	t.setCount(last, count)
 }
 
 func (t *tree) eraseAdjust(n, parent node) {
@@ -738,6 +2308,8 @@ func (t *tree) eraseAdjust(n, parent node) {
 // if end can get beg after multi Next method, it will panic with ErrNoLast.
 // O(count)
 func (t *tree) EraseNodeRange(beg, end _node) (count int) {
+	t.checkNode(beg)
+	t.checkNode(end)
 	return t.eraseNodeRange(beg.node, end.node)
 }
 func (t *tree) eraseNodeRange(beg, end node) (count int) {
@@ -754,8 +2326,18 @@ func (t *tree) eraseNodeRange(beg, end node) (count int) {
 // O(log(n))
 func (t *tree) LowerBound(_key interface{}) _node {
 	key := noescapeInterface(_key)
+	t.mustCheckKey(key)
 	return t.pack(t.lowerBound(key))
 }
+
+// TryLowerBound is like LowerBound, but it return ErrBadKey instead of panic.
+func (t *tree) TryLowerBound(_key interface{}) (_node, error) {
+	key := noescapeInterface(_key)
+	if err := t.checkKey(key); err != nil {
+		return t.End(), err
+	}
+	return t.pack(t.lowerBound(key)), nil
+}
 func (t *tree) lowerBound(key interface{}) node {
 	var root = t.root()
 	var parent = t.end()
@@ -777,12 +2359,104 @@ func (t *tree) lowerBound(key interface{}) node {
 	}
 }
 
+// seek return the first node not less than key after n, n must be less than key,
+// it climb up from n until the subtree contain key, and then go down like lowerBound,
+// so it's O(log(d)) where d is the distance of n and the result.
+func (t *tree) seek(n node, key interface{}) node {
+	var bound = t.end()
+	for !sameNode(t.getParent(n), t.end()) {
+		var parent = t.getParent(n)
+		if sameNode(t.getChild(parent, 0), n) && t.compare(key, t.getKey(parent)) <= 0 {
+			bound = parent
+			break
+		}
+		n = parent
+	}
+	for !sameNode(n, t.end()) {
+		if t.compare(key, t.getKey(n)) <= 0 {
+			bound = n
+			n = t.getChild(n, 0)
+		} else {
+			n = t.getChild(n, 1)
+		}
+	}
+	return bound
+}
+
+// nearest return the nearest node of key in a single descent, or end if there is no such node.
+// ch = 1: the first node greater than key, or not less than key if strict is false.
+// ch = 0: the last node less than key, or not greater than key if strict is false.
+// O(log(n))
+func (t *tree) nearest(key interface{}, ch uintptr, strict bool) node {
+	var n, result = t.root(), t.end()
+	for !sameNode(n, t.end()) {
+		var cmp = t.compare(t.getKey(n), key)
+		if ch == 0 {
+			cmp = -cmp
+		}
+		// cmp > 0 means n is on the ch side of key
+		if cmp > 0 || cmp == 0 && !strict {
+			result = n
+			n = t.getChild(n, ch^1)
+		} else {
+			n = t.getChild(n, ch)
+		}
+	}
+	return result
+}
+
+// Floor return the last _node not greater than key and true,
+// or End() and false if there is no such _node or the type of key is wrong.
+// O(log(n))
+func (t *tree) Floor(_key interface{}) (_node, bool) {
+	return t.nearestNode(noescapeInterface(_key), 0, false)
+}
+
+// Ceiling return the first _node not less than key and true,
+// or End() and false if there is no such _node or the type of key is wrong.
+// O(log(n))
+func (t *tree) Ceiling(_key interface{}) (_node, bool) {
+	return t.nearestNode(noescapeInterface(_key), 1, false)
+}
+
+// Lower return the last _node less than key and true,
+// or End() and false if there is no such _node or the type of key is wrong.
+// O(log(n))
+func (t *tree) Lower(_key interface{}) (_node, bool) {
+	return t.nearestNode(noescapeInterface(_key), 0, true)
+}
+
+// Higher return the first _node greater than key and true,
+// or End() and false if there is no such _node or the type of key is wrong.
+// O(log(n))
+func (t *tree) Higher(_key interface{}) (_node, bool) {
+	return t.nearestNode(noescapeInterface(_key), 1, true)
+}
+
+func (t *tree) nearestNode(key interface{}, ch uintptr, strict bool) (_node, bool) {
+	if t.checkKey(key) != nil {
+		return t.End(), false
+	}
+	var n = t.nearest(key, ch, strict)
+	return t.pack(n), !sameNode(n, t.end())
+}
+
 // UpperBound return the first _node greater than key
 // O(log(n))
 func (t *tree) UpperBound(_key interface{}) _node {
 	key := noescapeInterface(_key)
+	t.mustCheckKey(key)
 	return t.pack(t.upperBound(key))
 }
+
+// TryUpperBound is like UpperBound, but it return ErrBadKey instead of panic.
+func (t *tree) TryUpperBound(_key interface{}) (_node, error) {
+	key := noescapeInterface(_key)
+	if err := t.checkKey(key); err != nil {
+		return t.End(), err
+	}
+	return t.pack(t.upperBound(key)), nil
+}
 func (t *tree) upperBound(key interface{}) node {
 	var root = t.root()
 	var parent = t.end()
@@ -807,6 +2481,9 @@ func (t *tree) upperBound(key interface{}) node {
 //ch = 0:take n for center,left rotate parent down,n is parent's right child
 //ch = 1:take n for center,right rotate parent down,n is parent's left child
 func (t *tree) rotate(ch uintptr, n node) {
+	if onRotate != nil {
+		onRotate()
+	}
 	var (
 		tmp     = t.getChild(n, ch)
 		parent  = t.getParent(n)
@@ -814,6 +2491,9 @@ func (t *tree) rotate(ch uintptr, n node) {
 	)
 	t.setChild(n, ch, parent)
 	t.setChild(parent, ch^1, tmp)
+	// n take place of parent, so the count of n is the old count of parent
+	t.setCount(n, t.getCount(parent))
+	t.updateCount(parent)
 
 	if !sameNode(tmp, t.end()) {
 		t.setParent(tmp, parent)
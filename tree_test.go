@@ -2,11 +2,9 @@ package rbtree
 
 import (
 	"fmt"
-	"reflect"
 	"sort"
 	"strconv"
 	"testing"
-	"unsafe"
 
 	"github.com/cdongyang/library/algorithm"
 	"github.com/cdongyang/library/randint"
@@ -76,6 +74,9 @@ func (t *tree) check(root node) (l int, size int) {
 	if a != b {
 		panic("path length of black not equal")
 	}
+	if t.getCount(root) != s1+s2+1 || t.getCount(t.end()) != 0 {
+		panic("count error")
+	}
 	if t.getColor(root) == black {
 		return a + 1, s1 + s2 + 1
 	}
@@ -625,18 +626,84 @@ func ExampleMaxSpan() {
 	//512
 }
 
-func ExampleNoescapeInsert() {
-	var tree = NewTree(false)
-	var rand = benchRand
-	n := testing.AllocsPerRun(1000, func() {
-		_, ok := tree.Insert(noescapeInterface(rand.Int()), nil)
-		if !ok {
-			panic("insert error")
+func TestSpanSizeAfterExtract(t *testing.T) {
+	m := NewMap(int(0), int(0), CompareInt)
+	var handles []NodeHandle
+	for i := 0; i < 1000; i++ {
+		m.Insert(i, i)
+		if i%7 == 0 {
+			handles = append(handles, m.Extract(m.Find(i)))
 		}
-	})
-	fmt.Println(n)
-	// Output:
-	//0
+	}
+	for i := range m.spans {
+		if m.spans[i].size%8 != 0 {
+			t.Fatal("the size of span is not a multiple of 8", i, m.spans[i].size)
+		}
+	}
+	for _, h := range handles {
+		if _, ok := m.InsertHandle(h); !ok {
+			t.Fatal("InsertHandle error", h.GetKey())
+		}
+	}
+	if _, size := m.Check(); size != 1000 {
+		t.Fatal("size error", size)
+	}
+}
+
+func TestEraseRangeRotations(t *testing.T) {
+	const n = 1 << 14
+	s := NewSet(int(0), CompareInt)
+	for i := 0; i < n; i++ {
+		s.Insert(i)
+	}
+	var rotations int
+	onRotate = func() { rotations++ }
+	defer func() { onRotate = nil }()
+	if num := s.EraseRange(n/4, n/4*3, HalfOpen); num != n/2 {
+		t.Fatal("EraseRange error", num)
+	}
+	// an interior range is cut off by split and join, so the rotations is O(log(n))
+	if rotations > 10*14 {
+		t.Fatal("too many rotations", rotations)
+	}
+	if _, size := s.Check(); size != n/2 || s.Find(n/4-1).GetData() != n/4-1 || s.Find(n/4*3).GetData() != n/4*3 {
+		t.Fatal("tree error after EraseRange", size)
+	}
+}
+
+func TestCompactSpans(t *testing.T) {
+	s := NewSet(int(0), CompareInt)
+	for i := 0; i < 10000; i++ {
+		s.Insert(i)
+	}
+	for i := 1; i < 10000; i += 2 {
+		s.Erase(i)
+	}
+	var first, last = s.Begin(), s.Find(9998)
+	s.SetMaxSpan(256)
+	if freed := s.Compact(); freed <= 0 {
+		t.Fatal("Compact should free memory", freed)
+	}
+	// the spans at most half used are released, and the new spans are not greater than maxSpan
+	var released int
+	for i := range s.spans {
+		if size := s.spans[i].size; size == 0 {
+			released++
+		} else if size > 256 {
+			t.Fatal("the size of span is greater than maxSpan", i, size)
+		}
+	}
+	if released == 0 || !first.Valid() || first.GetData() != 0 || last.Valid() {
+		t.Fatal("Compact error", released)
+	}
+	if _, size := s.Check(); size != 5000 || s.Find(9998).GetData() != 9998 {
+		t.Fatal("size error", size)
+	}
+	// the released spans are skipped by Clone
+	c := s.Clone()
+	if _, size := c.Check(); size != 5000 {
+		t.Fatal("clone after Compact error", size)
+	}
 }
 
 func TestGC(t *testing.T) {
@@ -679,39 +746,3 @@ func TestGC(t *testing.T) {
 		test.MemStats("free node")
 	})
 }
-
-func TestGetArrayPtrOfSliceValue(t *testing.T) {
-	a := 10
-	atype := reflect.TypeOf(a)
-	avalue := reflect.ValueOf(a)
-	as := reflect.MakeSlice(reflect.SliceOf(atype), 4, 4)
-	asp := getArrayPtrOfSliceValue(as)
-	as.Index(0).Set(avalue)
-	as.Index(2).Set(avalue)
-	t.Log(*(*[4]int)(asp))
-	t.Log(pack2Iface(unpackIface(a)._type, arrayAt(asp, 2, 8)))
-	if *(*int)(asp) != a {
-		t.Logf("%+v\n%+v\n", as, *(*slice)((*eface)(unsafe.Pointer(&as)).p))
-		t.Fatal(*(*int)(asp))
-	}
-	b := &a
-	btype := reflect.TypeOf(b)
-	bvalue := reflect.ValueOf(b)
-	bs := reflect.MakeSlice(reflect.SliceOf(btype), 4, 4)
-	bsp := getArrayPtrOfSliceValue(bs)
-	bs.Index(0).Set(bvalue)
-	bs.Index(2).Set(bvalue)
-	t.Log(*(*[4]unsafe.Pointer)(bsp))
-	t.Log(pack2Iface(unpackIface(b)._type, *(*unsafe.Pointer)(arrayAt(bsp, 2, 8))), b)
-	if **(**int)(bsp) != a {
-		t.Fatal(**(**int)(bsp))
-	}
-	tree := NewTree2(false)
-	beg, ok := tree.Insert("10", b)
-	if !ok {
-		t.Fatal()
-	}
-	if beg.GetKey() != "10" || beg.GetVal() != b {
-		t.Fatal(beg.GetKey(), beg.GetVal())
-	}
-}